@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/knollit/http_frontend/endpoints"
+	"github.com/knollit/http_frontend/organizations"
+)
+
+// backendFlags returns a flag set for a command that talks to the backends
+// directly, and a function connecting to them once the flags are parsed.
+func backendFlags(env *commandEnv, name, usage string) (*flag.FlagSet, func() (*service, error)) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cert := fs.String("cert-path", os.Getenv("TLS_CERT_PATH"), "Path to cert file")
+	key := fs.String("key-path", os.Getenv("TLS_KEY_PATH"), "Path to private key file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: http_frontend %v [flags] %v\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs, func() (*service, error) {
+		s, err := env.connect(*cert, *key)
+		if err != nil {
+			return nil, err
+		}
+		return newService(s), nil
+	}
+}
+
+// commandCaller is who the backends are told commands are run by.
+func commandCaller() string {
+	return "cli:" + os.Getenv("USER")
+}
+
+// expectArgs checks that fs has as many arguments as usage names.
+func expectArgs(fs *flag.FlagSet, usage ...string) error {
+	if fs.NArg() != len(usage) {
+		fs.Usage()
+		return fmt.Errorf("expected %v", strings.Join(usage, " and "))
+	}
+	return nil
+}
+
+// table writes items as aligned columns under a header, or as JSON lines.
+type table struct {
+	tw  *tabwriter.Writer
+	enc *json.Encoder
+}
+
+func newTable(w io.Writer, asJSON bool, header ...string) *table {
+	if asJSON {
+		return &table{enc: json.NewEncoder(w)}
+	}
+	t := &table{tw: tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)}
+	fmt.Fprintln(t.tw, strings.Join(header, "\t"))
+	return t
+}
+
+// add writes v, or cells as a row of the table.
+func (t *table) add(v interface{}, cells ...string) error {
+	if t.enc != nil {
+		return t.enc.Encode(v)
+	}
+	_, err := fmt.Fprintln(t.tw, strings.Join(cells, "\t"))
+	return err
+}
+
+func (t *table) flush() error {
+	if t.tw != nil {
+		return t.tw.Flush()
+	}
+	return nil
+}
+
+func addOrganization(t *table, org *organization) error {
+	return t.add(representV1(org), org.ID, org.Name, org.DisplayName)
+}
+
+func addEndpoint(t *table, e *endpoint) error {
+	return t.add(representV1(e), e.ID, e.URL)
+}
+
+func healthCommand(env *commandEnv, args []string) error {
+	fs, connect := backendFlags(env, "health", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	svc, err := connect()
+	if err != nil {
+		return err
+	}
+	unhealthy := 0
+	for _, backend := range []struct {
+		name string
+		dial func() (net.Conn, error)
+	}{
+		{"organizations", svc.host.getOrgSvcConn},
+		{"endpoints", svc.host.getEndpointSvcConn},
+	} {
+		conn, err := backend.dial()
+		if err != nil {
+			unhealthy++
+			fmt.Fprintf(env.stdout, "%v: %v\n", backend.name, err)
+			continue
+		}
+		conn.Close()
+		fmt.Fprintf(env.stdout, "%v: ok\n", backend.name)
+	}
+	if unhealthy > 0 {
+		return fmt.Errorf("%v of 2 backends unavailable", unhealthy)
+	}
+	return nil
+}
+
+func orgsListCommand(env *commandEnv, args []string) error {
+	fs, connect := backendFlags(env, "orgs list", "")
+	prefix := fs.String("prefix", "", "Only list organizations whose names start with this")
+	asJSON := fs.Bool("json", false, "Write organizations as JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	svc, err := connect()
+	if err != nil {
+		return err
+	}
+	t := newTable(env.stdout, *asJSON, "ID", "NAME", "DISPLAY NAME")
+	err = svc.readIndex(func(p page) serviceMsg {
+		p.sort = "name"
+		p.prefix = *prefix
+		return &organization{action: organizations.ActionIndex, caller: commandCaller(), page: p}
+	}, func(item serviceMsg) error {
+		return addOrganization(t, item.(*organization))
+	})
+	if err != nil {
+		return err
+	}
+	return t.flush()
+}
+
+func orgsCreateCommand(env *commandEnv, args []string) error {
+	fs, connect := backendFlags(env, "orgs create", "<name>")
+	displayName := fs.String("display-name", "", "Display name of the organization. Defaults to its name")
+	asJSON := fs.Bool("json", false, "Write the organization as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs, "an organization name"); err != nil {
+		return err
+	}
+	name, err := organizationSlug(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid organization name: %v", err)
+	}
+	org := &organization{
+		Name:        name,
+		DisplayName: strings.TrimSpace(*displayName),
+		caller:      commandCaller(),
+	}
+	if len(org.DisplayName) == 0 {
+		org.DisplayName = fs.Arg(0)
+	}
+	svc, err := connect()
+	if err != nil {
+		return err
+	}
+	orgs, err := svc.sync(org)
+	if err != nil {
+		return err
+	}
+	if len(orgs) == 0 {
+		return errors.New("no response from the organization service")
+	}
+	if err := orgs[0].getErr(); err != nil {
+		return err
+	}
+	t := newTable(env.stdout, *asJSON, "ID", "NAME", "DISPLAY NAME")
+	if err := addOrganization(t, orgs[0].(*organization)); err != nil {
+		return err
+	}
+	return t.flush()
+}
+
+func orgsDeleteCommand(env *commandEnv, args []string) error {
+	fs, connect := backendFlags(env, "orgs delete", "<name>")
+	yes := fs.Bool("yes", false, "Confirm the deletion")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs, "an organization name"); err != nil {
+		return err
+	}
+	if !*yes {
+		return fmt.Errorf("refusing to delete organization %q without -yes", fs.Arg(0))
+	}
+	svc, err := connect()
+	if err != nil {
+		return err
+	}
+	org, err := lookupOrganization(svc, fs.Arg(0), commandCaller())
+	if err != nil {
+		return err
+	}
+	org.action = organizations.ActionDelete
+	org.caller = commandCaller()
+	orgs, err := svc.sync(org)
+	if err != nil {
+		return err
+	}
+	if len(orgs) == 0 {
+		return errors.New("no response from the organization service")
+	}
+	if err := orgs[0].getErr(); err != nil {
+		return err
+	}
+	fmt.Fprintf(env.stdout, "deleted organization %v\n", org.Name)
+	return nil
+}
+
+func endpointsListCommand(env *commandEnv, args []string) error {
+	fs, connect := backendFlags(env, "endpoints list", "<organization>")
+	asJSON := fs.Bool("json", false, "Write endpoints as JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs, "an organization name"); err != nil {
+		return err
+	}
+	svc, err := connect()
+	if err != nil {
+		return err
+	}
+	org, err := lookupOrganization(svc, fs.Arg(0), commandCaller())
+	if err != nil {
+		return err
+	}
+	t := newTable(env.stdout, *asJSON, "ID", "URL")
+	err = svc.readIndex(func(p page) serviceMsg {
+		p.sort = "url"
+		return &endpoint{OrganizationID: org.Name, Action: endpoints.ActionIndex, caller: commandCaller(), page: p}
+	}, func(item serviceMsg) error {
+		return addEndpoint(t, item.(*endpoint))
+	})
+	if err != nil {
+		return err
+	}
+	return t.flush()
+}
+
+func endpointsCreateCommand(env *commandEnv, args []string) error {
+	fs, connect := backendFlags(env, "endpoints create", "<organization> <url>")
+	schemaPath := fs.String("schema", "", "File to read the endpoint's JSON schema from, or - for standard input")
+	allowPrivate := fs.Bool("allow-private-endpoint-urls", false, "Accept a URL pointing at a private or loopback address")
+	asJSON := fs.Bool("json", false, "Write the endpoint as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs, "an organization name", "a URL"); err != nil {
+		return err
+	}
+	svc, err := connect()
+	if err != nil {
+		return err
+	}
+	policy := svc.host.urlPolicy
+	policy.rejectPrivate = !*allowPrivate
+	normalized, err := policy.normalize(context.Background(), fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	e := &endpoint{URL: normalized, Action: endpoints.ActionNew, caller: commandCaller()}
+	if len(*schemaPath) > 0 {
+		in := env.stdin
+		if *schemaPath != "-" {
+			f, err := os.Open(*schemaPath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		schema, err := ioutil.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		e.Schema = string(schema)
+	}
+
+	org, err := lookupOrganization(svc, fs.Arg(0), commandCaller())
+	if err != nil {
+		return err
+	}
+	e.OrganizationID = org.Name
+	resps, err := svc.sync(e)
+	if err != nil {
+		return err
+	}
+	if len(resps) == 0 {
+		return errors.New("no response from the endpoint service")
+	}
+	if err := resps[0].getErr(); err != nil {
+		return err
+	}
+	t := newTable(env.stdout, *asJSON, "ID", "URL")
+	if err := addEndpoint(t, resps[0].(*endpoint)); err != nil {
+		return err
+	}
+	return t.flush()
+}
+
+// endpointsGetCommand writes an endpoint, including its schema, as JSON.
+func endpointsGetCommand(env *commandEnv, args []string) error {
+	fs, connect := backendFlags(env, "endpoints get", "<organization> <id>")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs, "an organization name", "an endpoint ID"); err != nil {
+		return err
+	}
+	svc, err := connect()
+	if err != nil {
+		return err
+	}
+	org, err := lookupOrganization(svc, fs.Arg(0), commandCaller())
+	if err != nil {
+		return err
+	}
+	resps, err := svc.sync(&endpoint{
+		ID:             fs.Arg(1),
+		OrganizationID: org.Name,
+		Action:         endpoints.ActionRead,
+		caller:         commandCaller(),
+	})
+	if err != nil {
+		return err
+	}
+	if len(resps) == 0 || len(resps[0].getID()) == 0 && resps[0].getErr() == nil {
+		return fmt.Errorf("endpoint %v not found", fs.Arg(1))
+	}
+	if err := resps[0].getErr(); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(env.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(representV1(resps[0]))
+}
+
+// backendSendCommand sends one request to a backend and writes what was
+// exchanged, with every frame decoded.
+func backendSendCommand(env *commandEnv, args []string) error {
+	fs, connect := backendFlags(env, "backend send", "")
+	form := url.Values{}
+	for _, f := range []struct{ name, key, usage string }{
+		{"service", "service", "Backend to send to: organizations or endpoints"},
+		{"action", "action", "Action to send, by name or number"},
+		{"id", "id", "ID of the organization or endpoint"},
+		{"name", "name", "Name of the organization"},
+		{"display-name", "displayName", "Display name of the organization"},
+		{"organization", "organizationID", "Organization of the endpoint"},
+		{"url", "url", "URL of the endpoint"},
+		{"schema", "schema", "Schema of the endpoint"},
+		{"limit", "limit", "Page size of an index"},
+		{"cursor", "cursor", "ID of the last item on the previous page of an index"},
+		{"sort", "sort", "Sort order of an index"},
+		{"prefix", "prefix", "Prefix of the items of an index"},
+		{"caller", "caller", "Caller the request is made on behalf of"},
+	} {
+		fs.Var(formFlag{form, f.key}, f.name, f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(form.Get("caller")) == 0 {
+		form.Set("caller", commandCaller())
+	}
+	req, err := debugRequest(form)
+	if err != nil {
+		return err
+	}
+	svc, err := connect()
+	if err != nil {
+		return err
+	}
+	x := svc.exchange(req)
+	if err := writeDebugReport(env.stdout, describeExchange(x)); err != nil {
+		return err
+	}
+	if len(x.Error) > 0 {
+		return errors.New(x.Error)
+	}
+	return nil
+}
+
+// backendDecodeCommand writes the exchanges of a recording with every frame
+// decoded.
+func backendDecodeCommand(env *commandEnv, args []string) error {
+	fs := flag.NewFlagSet("backend decode", flag.ContinueOnError)
+	input := fs.String("f", "-", "Recording to decode, from -record-backends, or - for standard input")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	in := env.stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	exchanges, err := readRecording(in)
+	if err != nil {
+		return err
+	}
+	for _, x := range exchanges {
+		if err := writeDebugReport(env.stdout, describeExchange(x)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formFlag is a flag setting a form value.
+type formFlag struct {
+	form url.Values
+	key  string
+}
+
+func (f formFlag) String() string {
+	if f.form == nil {
+		return ""
+	}
+	return f.form.Get(f.key)
+}
+
+func (f formFlag) Set(v string) error {
+	f.form.Set(f.key, v)
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	tarMediaType = "application/x-tar"
+
+	// archiveVersion is the version of the archive format written by
+	// exports. Imports reject newer versions.
+	archiveVersion = 1
+)
+
+// archive is an organization with all its endpoints, as exported from one
+// environment and imported into another. It doesn't depend on the API
+// version, so archives outlive representations.
+type archive struct {
+	Version      int                  `json:"version"`
+	Organization archivedOrganization `json:"organization"`
+	Endpoints    []archivedEndpoint   `json:"endpoints"`
+}
+
+type archivedOrganization struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type archivedEndpoint struct {
+	// ID is the endpoint's ID in the environment it was exported from. It's
+	// informational, since the importing environment assigns its own.
+	ID     string `json:"id,omitempty"`
+	URL    string `json:"url"`
+	Schema string `json:"schema,omitempty"`
+}
+
+// In tar archives, the version and organization are in manifest.json, and
+// each endpoint is in endpoints/<ID>.json, with its schema alongside in
+// endpoints/<ID>.schema.json so it can be read and diffed as is.
+const (
+	tarManifestName   = "manifest.json"
+	tarEndpointsDir   = "endpoints/"
+	tarSchemaSuffix   = ".schema.json"
+	tarEndpointSuffix = ".json"
+)
+
+type tarManifest struct {
+	Version      int                  `json:"version"`
+	Organization archivedOrganization `json:"organization"`
+}
+
+func (a *archive) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a)
+}
+
+func (a *archive) writeTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	add := func(name string, v interface{}) error {
+		var data []byte
+		if s, ok := v.(string); ok {
+			data = []byte(s)
+		} else {
+			var err error
+			if data, err = json.MarshalIndent(v, "", "  "); err != nil {
+				return err
+			}
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: now}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	if err := add(tarManifestName, tarManifest{Version: a.Version, Organization: a.Organization}); err != nil {
+		return err
+	}
+	for i, e := range a.Endpoints {
+		name := e.ID
+		if len(name) == 0 || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\") {
+			name = fmt.Sprintf("%d", i)
+		}
+		schema := e.Schema
+		e.Schema = ""
+		if err := add(tarEndpointsDir+name+tarEndpointSuffix, e); err != nil {
+			return err
+		}
+		if len(schema) > 0 {
+			if err := add(tarEndpointsDir+name+tarSchemaSuffix, schema); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// readArchive decodes a JSON or tar archive, as told by mediaType.
+func readArchive(r io.Reader, mediaType string) (*archive, error) {
+	var a *archive
+	var err error
+	switch mediaType {
+	case jsonMediaType:
+		a = &archive{}
+		err = json.NewDecoder(r).Decode(a)
+	case tarMediaType:
+		a, err = readTar(r)
+	default:
+		return nil, fmt.Errorf("archives must be %v or %v", jsonMediaType, tarMediaType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if a.Version < 1 || a.Version > archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %v", a.Version)
+	}
+	return a, nil
+}
+
+func readTar(r io.Reader) (*archive, error) {
+	a := &archive{}
+	var names []string
+	endpoints := make(map[string]*archivedEndpoint)
+	schemas := make(map[string]string)
+	manifest := false
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(hdr.Name)
+		switch {
+		case name == tarManifestName:
+			var m tarManifest
+			if err := json.Unmarshal(data, &m); err != nil {
+				return nil, fmt.Errorf("%v: %v", name, err)
+			}
+			a.Version, a.Organization, manifest = m.Version, m.Organization, true
+		case strings.HasPrefix(name, tarEndpointsDir) && strings.HasSuffix(name, tarSchemaSuffix):
+			schemas[strings.TrimSuffix(strings.TrimPrefix(name, tarEndpointsDir), tarSchemaSuffix)] = string(data)
+		case strings.HasPrefix(name, tarEndpointsDir) && strings.HasSuffix(name, tarEndpointSuffix):
+			e := &archivedEndpoint{}
+			if err := json.Unmarshal(data, e); err != nil {
+				return nil, fmt.Errorf("%v: %v", name, err)
+			}
+			key := strings.TrimSuffix(strings.TrimPrefix(name, tarEndpointsDir), tarEndpointSuffix)
+			names = append(names, key)
+			endpoints[key] = e
+		}
+	}
+	if !manifest {
+		return nil, errors.New("archive has no " + tarManifestName)
+	}
+	for _, name := range names {
+		e := endpoints[name]
+		e.Schema = schemas[name]
+		a.Endpoints = append(a.Endpoints, *e)
+	}
+	return a, nil
+}
+
+// encodeArchive returns a in the format of mediaType.
+func encodeArchive(a *archive, mediaType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if mediaType == tarMediaType {
+		err = a.writeTar(&buf)
+	} else {
+		err = a.writeJSON(&buf)
+	}
+	return buf.Bytes(), err
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// role is the level of access a caller has within an organization. Roles are
+// ordered so that each one includes the permissions of those below it.
+type role int8
+
+const (
+	roleNone role = iota
+	roleViewer
+	roleEditor
+	roleAdmin
+)
+
+var roleNames = map[string]role{
+	"viewer": roleViewer,
+	"editor": roleEditor,
+	"admin":  roleAdmin,
+}
+
+func (r role) String() string {
+	for name, named := range roleNames {
+		if named == r {
+			return name
+		}
+	}
+	return ""
+}
+
+// caller is an authenticated API client. Role applies in every organization,
+// and to routes outside of them; Roles add to it in single organizations.
+type caller struct {
+	ID    string
+	Role  role
+	Roles map[string]role // keyed by organization slug
+}
+
+// role returns the role of c in the organization with slug org, or outside
+// of organizations if org is empty.
+func (c *caller) role(org string) role {
+	if r := c.Roles[org]; len(org) > 0 && r > c.Role {
+		return r
+	}
+	return c.Role
+}
+
+// policy maps HTTP methods to the minimum role a caller needs in the
+// requested organization. Methods missing from a policy are denied.
+type policy map[string]role
+
+var (
+	// organizationsPolicy applies to the list of every organization, so it
+	// takes a role outside of organizations.
+	organizationsPolicy = policy{
+		http.MethodGet:  roleViewer,
+		http.MethodPost: roleEditor,
+	}
+	organizationPolicy = policy{
+		http.MethodGet:    roleViewer,
+		http.MethodPut:    roleAdmin,
+		http.MethodDelete: roleAdmin,
+	}
+	endpointPolicy = policy{
+		http.MethodGet:    roleViewer,
+		http.MethodPost:   roleEditor,
+		http.MethodPut:    roleEditor,
+		http.MethodPatch:  roleEditor,
+		http.MethodDelete: roleAdmin,
+	}
+	// importPolicy only lets admins import, since imports can overwrite.
+	importPolicy = policy{
+		http.MethodPost: roleAdmin,
+	}
+	// memberPolicy only lets admins see and manage who else has access.
+	memberPolicy = policy{
+		http.MethodGet:    roleAdmin,
+		http.MethodPut:    roleAdmin,
+		http.MethodDelete: roleAdmin,
+	}
+)
+
+type callerContextKey struct{}
+
+// callerID returns the ID of the caller attached to r by authorize, or an
+// empty string if the request is anonymous.
+func callerID(r *http.Request) string {
+	if c, ok := r.Context().Value(callerContextKey{}).(*caller); ok {
+		return c.ID
+	}
+	return ""
+}
+
+// authorize authenticates the caller and checks their role in the requested
+// organization, or outside of organizations on routes without one, against p
+// before calling next. A role granted by an organization's admins counts if
+// it's higher than the caller's own. Authorization is skipped entirely if
+// the server has no authenticator, which serve only allows when asked to.
+func (s *server) authorize(p policy, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.authenticate == nil {
+			next(w, r)
+			return
+		}
+		c, err := s.authenticate(r)
+		if err != nil {
+			log.Println("authentication error: ", err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		org := requestOrganizationSlug(r)
+		granted := c.role(org)
+		if member := s.members.role(org, c.ID); len(org) > 0 && member > granted {
+			granted = member
+		}
+		if required, ok := p[r.Method]; !ok || granted < required {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), callerContextKey{}, c)))
+	}
+}
+
+var (
+	errNoCredentials = errors.New("no API key")
+	errUnknownAPIKey = errors.New("unknown API key")
+)
+
+// apiKeys authenticate callers by the API key they send as a bearer token.
+// Keys are looked up by their SHA-256 hash, so the file configuring them
+// holds no usable secrets.
+type apiKeys map[string]*caller
+
+// apiKeyConfig is the caller an API key authenticates, as configured.
+type apiKeyConfig struct {
+	ID    string            `json:"id"`
+	Role  string            `json:"role"`
+	Roles map[string]string `json:"roles"`
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseRole(name string) (role, error) {
+	if len(name) == 0 {
+		return roleNone, nil
+	}
+	if r, ok := roleNames[name]; ok {
+		return r, nil
+	}
+	return roleNone, fmt.Errorf("unknown role %q", name)
+}
+
+// readAPIKeys reads a JSON object mapping the hex SHA-256 hash of each API
+// key to the caller it authenticates, like
+//
+//	{"<hash>": {"id": "ci", "role": "viewer", "roles": {"acme": "admin"}}}
+func readAPIKeys(r io.Reader) (apiKeys, error) {
+	var configs map[string]apiKeyConfig
+	if err := json.NewDecoder(r).Decode(&configs); err != nil {
+		return nil, err
+	}
+	keys := make(apiKeys, len(configs))
+	for hash, config := range configs {
+		if len(config.ID) == 0 {
+			return nil, fmt.Errorf("key %v: id is required", hash)
+		}
+		c := &caller{ID: config.ID, Roles: make(map[string]role, len(config.Roles))}
+		var err error
+		if c.Role, err = parseRole(config.Role); err != nil {
+			return nil, fmt.Errorf("key %v: %v", hash, err)
+		}
+		for org, name := range config.Roles {
+			if c.Roles[strings.ToLower(org)], err = parseRole(name); err != nil {
+				return nil, fmt.Errorf("key %v: %v", hash, err)
+			}
+		}
+		keys[strings.ToLower(hash)] = c
+	}
+	return keys, nil
+}
+
+// authenticate returns the caller whose API key r carries.
+func (keys apiKeys) authenticate(r *http.Request) (*caller, error) {
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(key) == 0 {
+		return nil, errNoCredentials
+	}
+	c, ok := keys[hashAPIKey(key)]
+	if !ok {
+		return nil, errUnknownAPIKey
+	}
+	return c, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/knollit/http_frontend/endpoints"
+)
+
+const (
+	// maxBatchSize is the most endpoints a batch may create.
+	maxBatchSize = 1000
+	// maxBatchLookups is how many of a batch's URLs are resolved at once.
+	maxBatchLookups = 16
+)
+
+// batchItem is an endpoint to create in a batch.
+type batchItem struct {
+	URL    string `json:"url"`
+	Schema string `json:"schema"`
+}
+
+// batchResult is the outcome of one item of a batch, with the status a
+// request creating it alone would have had.
+type batchResult struct {
+	Status   int           `json:"status"`
+	Location string        `json:"location,omitempty"`
+	Data     interface{}   `json:"data,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Errors   []*fieldError `json:"errors,omitempty"`
+}
+
+// endpointsBatchHandler creates a JSON array of endpoints, looking up their
+// organization once and sending a New request for each over a single
+// connection to the endpoint service. It responds 207 with a result per item,
+// in order. Atomic batches, creating every endpoint or none, need support
+// from the endpoint service, so ?atomic=true is answered with 501.
+func (s *server) endpointsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get(contentTypeHeader)); mediaType != jsonMediaType {
+		http.Error(w, "batches must be "+jsonMediaType, http.StatusUnsupportedMediaType)
+		return
+	}
+	if atomic, _ := strconv.ParseBool(r.URL.Query().Get("atomic")); atomic {
+		http.Error(w, "atomic batches are not supported", http.StatusNotImplemented)
+		return
+	}
+	var items []batchItem
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		writeFieldErrors(w, &fieldError{In: "body", Message: "must be a JSON array of endpoints", malformed: true})
+		return
+	}
+	if len(items) == 0 || len(items) > maxBatchSize {
+		writeFieldErrors(w, &fieldError{In: "body", Message: "must have between 1 and " + strconv.Itoa(maxBatchSize) + " endpoints"})
+		return
+	}
+
+	svc := s.getService()
+	defer s.putService(svc)
+	org := s.findOrganization(w, r, svc)
+	if org == nil {
+		return
+	}
+	r = withOrganization(r, org)
+
+	results := make([]batchResult, len(items))
+	var reqs []serviceMsg
+	// sent maps the requests to the items they create.
+	var sent []int
+	normalized, errs := s.normalizeBatch(r.Context(), items)
+	for i, item := range items {
+		if err := errs[i]; err != nil {
+			results[i] = batchResult{
+				Status: http.StatusUnprocessableEntity,
+				Errors: []*fieldError{{In: "body", Field: "url", Message: err.Error()}},
+			}
+			continue
+		}
+		reqs = append(reqs, &endpoint{
+			OrganizationID: org.Name,
+			URL:            normalized[i],
+			Schema:         item.Schema,
+			Action:         endpoints.ActionNew,
+			caller:         callerID(r),
+		})
+		sent = append(sent, i)
+	}
+	for _, i := range sent {
+		results[i] = batchResult{Status: http.StatusBadGateway, Error: "no response from the endpoint service"}
+	}
+
+	err := svc.syncEach(reqs, func(j int, resp serviceMsg) error {
+		i := sent[j]
+		if err := resp.getErr(); err != nil {
+			results[i] = batchResult{Status: http.StatusBadRequest, Error: err.Error()}
+			return nil
+		}
+		data := represent(r, resp)
+		results[i] = batchResult{Status: http.StatusCreated, Location: resp.(*endpoint).Self, Data: data}
+		return nil
+	})
+	if err != nil {
+		log.Printf("endpoint batch request error %v", err)
+	}
+	writeBatchResults(w, results)
+}
+
+// normalizeBatch applies the server's URL policy to the URLs of items,
+// resolving up to maxBatchLookups of them at once so a batch of slow hosts
+// doesn't take a lookup timeout per item. Lookups still under way when ctx
+// is done are abandoned.
+func (s *server) normalizeBatch(ctx context.Context, items []batchItem) (normalized []string, errs []error) {
+	normalized = make([]string, len(items))
+	errs = make([]error, len(items))
+	sem := make(chan struct{}, maxBatchLookups)
+	var wg sync.WaitGroup
+	for i := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			normalized[i], errs[i] = s.urlPolicy.normalize(ctx, items[i].URL)
+			<-sem
+		}(i)
+	}
+	wg.Wait()
+	return
+}
+
+func writeBatchResults(w http.ResponseWriter, results []batchResult) {
+	w.Header().Set(contentTypeHeader, jsonContentTypeValue)
+	w.WriteHeader(http.StatusMultiStatus)
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// command is a subcommand of the binary, run as
+// "http_frontend <command> [flags]".
+type command func(env *commandEnv, args []string) error
+
+// commandEnv is what commands read from, write to and connect with.
+type commandEnv struct {
+	stdin  io.Reader
+	stdout io.Writer
+	// connect returns a server whose services reach the backends, using the
+	// client cert at certPath and keyPath.
+	connect func(certPath, keyPath string) (*server, error)
+}
+
+// commands are the subcommands of the binary. Without one, it serves the API.
+var commands = map[string]command{
+	"serve":  serveCommand,
+	"export": exportCommand,
+	"import": importCommand,
+	"health": healthCommand,
+	"orgs": subcommands("orgs", map[string]command{
+		"list":   orgsListCommand,
+		"create": orgsCreateCommand,
+		"delete": orgsDeleteCommand,
+	}),
+	"endpoints": subcommands("endpoints", map[string]command{
+		"list":   endpointsListCommand,
+		"create": endpointsCreateCommand,
+		"get":    endpointsGetCommand,
+	}),
+	"backend": subcommands("backend", map[string]command{
+		"send":   backendSendCommand,
+		"decode": backendDecodeCommand,
+	}),
+}
+
+// runCommand runs the command named by args[0].
+func runCommand(env *commandEnv, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: http_frontend <%v> [flags]", strings.Join(commandNames(commands), "|"))
+	}
+	c, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q; expected one of %v", args[0], strings.Join(commandNames(commands), ", "))
+	}
+	return c(env, args[1:])
+}
+
+// subcommands returns a command that runs the one of cmds named by its first
+// argument.
+func subcommands(name string, cmds map[string]command) command {
+	return func(env *commandEnv, args []string) error {
+		if len(args) == 0 {
+			return fmt.Errorf("usage: http_frontend %v <%v> [flags]", name, strings.Join(commandNames(cmds), "|"))
+		}
+		c, ok := cmds[args[0]]
+		if !ok {
+			return fmt.Errorf("unknown %v command %q; expected one of %v", name, args[0], strings.Join(commandNames(cmds), ", "))
+		}
+		return c(env, args[1:])
+	}
+}
+
+func commandNames(cmds map[string]command) []string {
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// apiFlags are where a subcommand calls the API, and with which key.
+type apiFlags struct {
+	url string
+	key string
+}
+
+// do sends req, authenticated with the API key if there is one.
+func (api *apiFlags) do(req *http.Request) (*http.Response, error) {
+	if len(api.key) > 0 {
+		req.Header.Set("Authorization", "Bearer "+api.key)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// commandFlags returns a flag set for a subcommand that takes the API's
+// address and key, and the organization as its only argument.
+func commandFlags(name, usage string) (*flag.FlagSet, *apiFlags) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	api := new(apiFlags)
+	fs.StringVar(&api.url, "api", envOr("HTTP_FRONTEND_URL", "http://localhost"), "Base URL of the API")
+	fs.StringVar(&api.key, "api-key", os.Getenv("HTTP_FRONTEND_API_KEY"), "API key to call the API with")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: http_frontend %v [flags] %v\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs, api
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); len(v) > 0 {
+		return v
+	}
+	return fallback
+}
+
+// organizationURL returns the URL of a v1 route of the organization named by
+// the only argument of fs.
+func organizationURL(fs *flag.FlagSet, api, suffix string) (string, error) {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return "", errors.New("expected an organization name")
+	}
+	return strings.TrimSuffix(api, "/") + "/v1/organizations/" + url.PathEscape(fs.Arg(0)) + suffix, nil
+}
+
+// checkResponse returns an error carrying the body of res unless its status
+// is one of ok.
+func checkResponse(res *http.Response, ok ...int) error {
+	for _, status := range ok {
+		if res.StatusCode == status {
+			return nil
+		}
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4<<10))
+	return fmt.Errorf("%v: %s", res.Status, strings.TrimSpace(string(body)))
+}
+
+func exportCommand(env *commandEnv, args []string) error {
+	fs, api := commandFlags("export", "<organization>")
+	format := fs.String("format", "json", "Archive format: json or tar")
+	output := fs.String("o", "-", "File to write the archive to, or - for standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	exportURL, err := organizationURL(fs, api.url, "/export")
+	if err != nil {
+		return err
+	}
+	mediaType, err := archiveMediaType(*format)
+	if err != nil {
+		return err
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, exportURL, nil)
+	req.Header.Set("Accept", mediaType)
+	res, err := api.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := checkResponse(res, http.StatusOK); err != nil {
+		return err
+	}
+
+	out := env.stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	_, err = io.Copy(out, res.Body)
+	return err
+}
+
+func importCommand(env *commandEnv, args []string) error {
+	fs, api := commandFlags("import", "<organization>")
+	format := fs.String("format", "", "Archive format: json or tar. Defaults to the input file's extension, or json")
+	input := fs.String("f", "-", "File to read the archive from, or - for standard input")
+	conflict := fs.String("conflict", conflictFail, "What to do with existing organizations and endpoints: fail, skip or overwrite")
+	dryRun := fs.Bool("dry-run", false, "Report what would be imported without importing it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	importURL, err := organizationURL(fs, api.url, "/import")
+	if err != nil {
+		return err
+	}
+	if len(*format) == 0 {
+		*format = "json"
+		if strings.HasSuffix(*input, ".tar") {
+			*format = "tar"
+		}
+	}
+	mediaType, err := archiveMediaType(*format)
+	if err != nil {
+		return err
+	}
+
+	in := env.stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	q := url.Values{"conflict": {*conflict}, "dryRun": {strconv.FormatBool(*dryRun)}}
+	req, _ := http.NewRequest(http.MethodPost, importURL+"?"+q.Encode(), in)
+	req.Header.Set(contentTypeHeader, mediaType)
+	res, err := api.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := checkResponse(res, http.StatusOK, http.StatusCreated, http.StatusMultiStatus, http.StatusConflict); err != nil {
+		return err
+	}
+
+	// The report says what was, or would have been, imported.
+	if _, err := io.Copy(env.stdout, res.Body); err != nil {
+		return err
+	}
+	switch res.StatusCode {
+	case http.StatusConflict:
+		return errors.New("import conflicts with existing data; nothing was imported")
+	case http.StatusMultiStatus:
+		return errors.New("import partially failed")
+	}
+	return nil
+}
+
+func archiveMediaType(format string) (string, error) {
+	switch format {
+	case "json":
+		return jsonMediaType, nil
+	case "tar":
+		return tarMediaType, nil
+	}
+	return "", fmt.Errorf("unknown archive format %q", format)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	gzipEncoding = "gzip"
+
+	defaultCompressionMinSize = 1024
+)
+
+var defaultCompressionTypes = []string{
+	jsonMediaType,
+	ndjsonMediaType,
+	eventStreamMediaType,
+	msgpackMediaType,
+	flatbuffersMediaType,
+	"text/plain",
+}
+
+// encoder is implemented by the writers of each encoding.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressionPolicy decides which responses are compressed.
+type compressionPolicy struct {
+	// minSize is the smallest body worth compressing. Streamed responses are
+	// compressed regardless, since their size isn't known up front.
+	minSize int
+	types   map[string]struct{}
+	pools   map[string]*sync.Pool
+}
+
+func newCompressionPolicy(minSize int, types []string) *compressionPolicy {
+	p := &compressionPolicy{
+		minSize: minSize,
+		types:   make(map[string]struct{}),
+		pools: map[string]*sync.Pool{
+			gzipEncoding: {New: func() interface{} {
+				return gzip.NewWriter(nil)
+			}},
+		},
+	}
+	for _, t := range types {
+		p.types[t] = struct{}{}
+	}
+	return p
+}
+
+// acceptedEncoding returns the encoding r accepts with the highest quality,
+// or "" if it accepts none the server supports.
+func acceptedEncoding(r *http.Request) string {
+	quality := map[string]float64{}
+	for _, spec := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(spec, ";")
+		coding := strings.ToLower(strings.TrimSpace(parts[0]))
+		q := 1.0
+		for _, param := range parts[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				if f, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if len(coding) > 0 {
+			quality[coding] = q
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, coding := range []string{gzipEncoding} {
+		q, ok := quality[coding]
+		if !ok {
+			q = quality["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// handler compresses the responses of next that the policy allows with the
+// encoding the request accepts.
+func (p *compressionPolicy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r)
+		if len(encoding) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, policy: p, encoding: encoding, ifNoneMatch: r.Header.Get("If-None-Match")}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the start of a response until it can tell whether
+// to compress it: when the body reaches the policy's minimum size, when the
+// handler flushes, or when the handler returns.
+type compressWriter struct {
+	http.ResponseWriter
+	policy      *compressionPolicy
+	encoding    string
+	ifNoneMatch string
+	status      int
+	buf         []byte
+	decided     bool
+	encoder     encoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified || status < 200 {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) >= cw.policy.minSize {
+			cw.decide(false)
+		}
+		return len(b), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been written so far, so streamed responses reach
+// clients item by item.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) compressible(streaming bool) bool {
+	h := cw.ResponseWriter.Header()
+	if len(h.Get("Content-Encoding")) > 0 || len(cw.buf) == 0 && !streaming {
+		return false
+	}
+	if cw.status == http.StatusNoContent || cw.status == http.StatusNotModified || cw.status != 0 && cw.status < 200 {
+		return false
+	}
+	if !streaming && len(cw.buf) < cw.policy.minSize {
+		return false
+	}
+	if len(h.Get(contentTypeHeader)) == 0 {
+		h.Set(contentTypeHeader, http.DetectContentType(cw.buf))
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get(contentTypeHeader))
+	if err != nil {
+		return false
+	}
+	_, ok := cw.policy.types[mediaType]
+	return ok
+}
+
+// decide sends the response headers, compressing the body from here on if
+// the policy allows. A compressed response's entity tag is marked with the
+// encoding, as is that of a 304 to a client holding the compressed
+// representation.
+func (cw *compressWriter) decide(streaming bool) {
+	cw.decided = true
+	h := cw.ResponseWriter.Header()
+	tag := h.Get("ETag")
+	if cw.compressible(streaming) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		if len(tag) > 0 {
+			h.Set("ETag", encodedETag(tag, cw.encoding))
+		}
+		cw.encoder = cw.policy.pools[cw.encoding].Get().(encoder)
+		cw.encoder.Reset(cw.ResponseWriter)
+	} else if encoded := encodedETag(tag, cw.encoding); cw.status == http.StatusNotModified && len(tag) > 0 && strings.Contains(cw.ifNoneMatch, encoded) {
+		h.Set("ETag", encoded)
+	}
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if len(cw.buf) > 0 {
+		cw.Write(cw.buf)
+	}
+	cw.buf = nil
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Close()
+		cw.encoder.Reset(nil)
+		cw.policy.pools[cw.encoding].Put(cw.encoder)
+		cw.encoder = nil
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/google/flatbuffers/go"
+)
+
+// etag returns a strong entity tag for msg, computed from its serialized
+// form.
+func etag(b *flatbuffers.Builder, msg serviceMsg) string {
+	sum := sha1.Sum(msg.toFlatBufferBytes(b))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// encodedETag returns the entity tag of the representation tagged tag once
+// compressed with encoding, like "hash-gzip", since it's a different
+// sequence of bytes.
+func encodedETag(tag, encoding string) string {
+	if !strings.HasSuffix(tag, `"`) {
+		return tag
+	}
+	return tag[:len(tag)-1] + "-" + encoding + `"`
+}
+
+// decodedETag returns the entity tag of the uncompressed representation of a
+// tag returned by encodedETag.
+func decodedETag(tag string) string {
+	for _, encoding := range []string{gzipEncoding} {
+		if suffix := "-" + encoding + `"`; strings.HasSuffix(tag, suffix) {
+			return tag[:len(tag)-len(suffix)] + `"`
+		}
+	}
+	return tag
+}
+
+// etagListContains reports whether the comma-separated entity tags in header
+// include tag, in any of its encodings. Weak tags only match if weak
+// comparison is allowed.
+func etagListContains(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || decodedETag(candidate) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifNoneMatch reports whether a GET for an entity tagged tag can be answered
+// with 304 Not Modified.
+func ifNoneMatch(r *http.Request, tag string) bool {
+	header := r.Header.Get("If-None-Match")
+	return len(header) > 0 && etagListContains(header, tag, true)
+}
+
+// ifMatch checks that a modifying request names the current entity tag. If
+// it doesn't, an error response is written and false is returned.
+func ifMatch(w http.ResponseWriter, r *http.Request, tag string) bool {
+	header := r.Header.Get("If-Match")
+	if len(header) == 0 {
+		http.Error(w, "If-Match header required", http.StatusPreconditionRequired)
+		return false
+	}
+	if !etagListContains(header, tag, false) {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
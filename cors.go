@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	defaultCORSMethods = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodPost,
+		http.MethodPut,
+		http.MethodPatch,
+		http.MethodDelete,
+	}
+	defaultCORSHeaders = []string{
+		"Accept",
+		"Authorization",
+		"Content-Type",
+		"Idempotency-Key",
+		"If-Match",
+		"If-None-Match",
+	}
+	// corsExposedHeaders are the response headers beyond the CORS-safelisted
+	// ones that browser clients need to read.
+	corsExposedHeaders = []string{
+		"Deprecation",
+		"ETag",
+		"Idempotent-Replayed",
+		"Link",
+		"Location",
+		"RateLimit-Limit",
+		"RateLimit-Remaining",
+		"RateLimit-Reset",
+		"Retry-After",
+		"Stream-Error",
+		"Sunset",
+	}
+)
+
+var errCORSAnyOriginCredentials = errors.New("cross-origin credentials can't be allowed from any origin; list the origins instead of *")
+
+// corsPolicy decides which cross-origin requests browsers may make.
+type corsPolicy struct {
+	// origins are allowed origins, like "https://console.knoll.it". An
+	// origin may have a wildcard subdomain, like "https://*.knoll.it", and
+	// "*" allows any origin, but only without credentials.
+	origins     []string
+	methods     []string
+	headers     []string
+	credentials bool
+	maxAge      time.Duration
+}
+
+// validate returns an error if the policy would let any site make requests
+// with its visitors' credentials.
+func (p *corsPolicy) validate() error {
+	if p.credentials && p.allowsAnyOrigin() {
+		return errCORSAnyOriginCredentials
+	}
+	return nil
+}
+
+func (p *corsPolicy) allowsAnyOrigin() bool {
+	for _, allowed := range p.origins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated list, dropping empty elements.
+func splitList(list string) (elems []string) {
+	for _, elem := range strings.Split(list, ",") {
+		if elem = strings.TrimSpace(elem); len(elem) > 0 {
+			elems = append(elems, elem)
+		}
+	}
+	return
+}
+
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	for _, allowed := range p.origins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// A wildcard matches one or more subdomain labels, but not the bare
+		// domain.
+		if i := strings.Index(allowed, "*."); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+				!strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handler adds CORS headers to responses to allowed origins, and answers
+// their preflight requests itself, so preflights never reach the backends.
+func (p *corsPolicy) handler(next http.Handler) http.Handler {
+	methods := strings.Join(p.methods, ", ")
+	headers := strings.Join(p.headers, ", ")
+	exposed := strings.Join(corsExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(p.maxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 || !p.allowsOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch {
+		case p.credentials:
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		case p.allowsAnyOrigin():
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		default:
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0 {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			if p.maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", exposed)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/flatbuffers/go"
+)
+
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	actionField
+	int32Field
+	uint64Field
+)
+
+type tableField struct {
+	name string
+	kind fieldKind
+}
+
+// tableFields are the fields of each backend's table in schema order, so a
+// field's index is its slot in the vtable. Keep them in step with the .fbs
+// files.
+var tableFields = map[string][]tableField{
+	organizationService: {
+		{"error", stringField},
+		{"action", actionField},
+		{"name", stringField},
+		{"ID", stringField},
+		{"caller", stringField},
+		{"limit", int32Field},
+		{"cursor", stringField},
+		{"sort", stringField},
+		{"prefix", stringField},
+		{"display_name", stringField},
+		{"version", uint64Field},
+	},
+	endpointService: {
+		{"id", stringField},
+		{"organizationID", stringField},
+		{"URL", stringField},
+		{"action", actionField},
+		{"error", stringField},
+		{"schema", stringField},
+		{"caller", stringField},
+		{"limit", int32Field},
+		{"cursor", stringField},
+		{"sort", stringField},
+		{"prefix", stringField},
+		{"version", uint64Field},
+	},
+}
+
+// actionNames are the names of each backend's Action enum, by value.
+var actionNames = map[string][]string{
+	organizationService: {"New", "Index", "Read", "Update", "Delete"},
+	endpointService:     {"New", "Index", "Read", "Update", "Delete"},
+}
+
+// parseAction returns the action of service named by s, which may also be
+// any number, so unknown actions can be sent.
+func parseAction(service, s string) (int8, error) {
+	for i, name := range actionNames[service] {
+		if strings.EqualFold(name, s) {
+			return int8(i), nil
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("action must be a number or one of %v", strings.Join(actionNames[service], ", "))
+	}
+	return int8(n), nil
+}
+
+// decodedTable is every field present in a table. Fields in slots the schema
+// doesn't know are listed by slot, with their offset in the table, since
+// their types can't be known.
+type decodedTable struct {
+	Fields  map[string]interface{} `json:"fields"`
+	Unknown []unknownField         `json:"unknown,omitempty"`
+}
+
+type unknownField struct {
+	Slot   int `json:"slot"`
+	Offset int `json:"offset"`
+}
+
+// decodedFrame is a frame decoded for debugging. Error is set if the frame
+// couldn't be decoded.
+type decodedFrame struct {
+	Size    int    `json:"size"`
+	Latency string `json:"latency,omitempty"`
+	decodedTable
+	Error string `json:"error,omitempty"`
+}
+
+// fieldSizes are the sizes of the fields of each kind within a table. A
+// string field is the offset of the string.
+var fieldSizes = map[fieldKind]int{
+	stringField: flatbuffers.SizeUOffsetT,
+	actionField: flatbuffers.SizeInt8,
+	int32Field:  flatbuffers.SizeInt32,
+	uint64Field: flatbuffers.SizeUint64,
+}
+
+// decodeTable decodes the fields of t, checking every offset it follows
+// against the bounds of the frame first.
+func decodeTable(service string, t flatbuffers.Table) (decodedTable, error) {
+	d := decodedTable{Fields: make(map[string]interface{})}
+	size := len(t.Bytes)
+	inBounds := func(pos, n int) bool {
+		return pos >= 0 && n >= 0 && pos <= size-n
+	}
+	if !inBounds(int(t.Pos), flatbuffers.SizeSOffsetT) {
+		return d, errors.New("table out of bounds")
+	}
+	vtable := int(t.Pos) - int(t.GetSOffsetT(t.Pos))
+	if !inBounds(vtable, 2*flatbuffers.SizeVOffsetT) {
+		return d, errors.New("vtable out of bounds")
+	}
+	vtableSize := int(t.GetVOffsetT(flatbuffers.UOffsetT(vtable)))
+	if vtableSize < 4 || !inBounds(vtable, vtableSize) {
+		return d, errors.New("vtable out of bounds")
+	}
+	slots := (vtableSize - 4) / 2
+	known := tableFields[service]
+	for slot := 0; slot < slots; slot++ {
+		o := flatbuffers.UOffsetT(t.Offset(flatbuffers.VOffsetT(4 + 2*slot)))
+		if o == 0 {
+			continue
+		}
+		if slot >= len(known) {
+			d.Unknown = append(d.Unknown, unknownField{Slot: slot, Offset: int(o)})
+			continue
+		}
+		f := known[slot]
+		pos := int(t.Pos) + int(o)
+		if !inBounds(pos, fieldSizes[f.kind]) {
+			return d, fmt.Errorf("field %v out of bounds", f.name)
+		}
+		switch f.kind {
+		case stringField:
+			str := pos + int(t.GetUOffsetT(flatbuffers.UOffsetT(pos)))
+			if !inBounds(str, flatbuffers.SizeUOffsetT) || !inBounds(str+flatbuffers.SizeUOffsetT, int(t.GetUOffsetT(flatbuffers.UOffsetT(str)))) {
+				return d, fmt.Errorf("field %v out of bounds", f.name)
+			}
+			d.Fields[f.name] = string(t.ByteVector(t.Pos + o))
+		case actionField:
+			action := t.GetInt8(t.Pos + o)
+			if int(action) >= 0 && int(action) < len(actionNames[service]) {
+				d.Fields[f.name] = actionNames[service][action]
+			} else {
+				d.Fields[f.name] = action
+			}
+		case int32Field:
+			d.Fields[f.name] = t.GetInt32(t.Pos + o)
+		case uint64Field:
+			d.Fields[f.name] = t.GetUint64(t.Pos + o)
+		}
+	}
+	return d, nil
+}
+
+// decodeFrame decodes every field of a frame from service, without trusting
+// it to be well formed.
+func decodeFrame(service string, frame []byte) (d decodedFrame) {
+	d.Size = len(frame)
+	if len(frame) < flatbuffers.SizeUOffsetT {
+		d.Error = "malformed frame: too short"
+		return
+	}
+	table, err := decodeTable(service, flatbuffers.Table{Bytes: frame, Pos: flatbuffers.GetUOffsetT(frame)})
+	if err != nil {
+		d.Error = "malformed frame: " + err.Error()
+		return
+	}
+	d.decodedTable = table
+	return
+}
+
+// debugReport is an exchange with a backend decoded for debugging.
+type debugReport struct {
+	Service   string         `json:"service"`
+	Time      time.Time      `json:"time"`
+	Request   decodedFrame   `json:"request"`
+	Responses []decodedFrame `json:"responses"`
+	Duration  string         `json:"duration"`
+	Error     string         `json:"error,omitempty"`
+}
+
+func describeExchange(x *exchange) debugReport {
+	report := debugReport{
+		Service:   x.Service,
+		Time:      x.Time,
+		Request:   decodeFrame(x.Service, x.Request),
+		Responses: make([]decodedFrame, len(x.Responses)),
+		Duration:  x.Duration.String(),
+		Error:     x.Error,
+	}
+	for i, resp := range x.Responses {
+		report.Responses[i] = decodeFrame(x.Service, resp.Frame)
+		report.Responses[i].Latency = resp.After.String()
+	}
+	return report
+}
+
+// debugRequest returns the request to a backend described by form: its
+// service, action and any of the fields the frontend sends. The limit is a
+// page size; the backend is sent one more, as it is by the frontend.
+func debugRequest(form url.Values) (serviceMsg, error) {
+	service := form.Get("service")
+	if _, ok := tableFields[service]; !ok {
+		return nil, fmt.Errorf("service must be %v or %v", organizationService, endpointService)
+	}
+	action, err := parseAction(service, form.Get("action"))
+	if err != nil {
+		return nil, err
+	}
+	p := page{after: form.Get("cursor"), sort: form.Get("sort"), prefix: form.Get("prefix")}
+	if limit := form.Get("limit"); len(limit) > 0 {
+		if p.limit, err = strconv.Atoi(limit); err != nil || p.limit < 0 {
+			return nil, errors.New("limit must not be negative")
+		}
+	}
+	if service == organizationService {
+		return &organization{
+			ID:          form.Get("id"),
+			Name:        form.Get("name"),
+			DisplayName: form.Get("displayName"),
+			action:      action,
+			caller:      form.Get("caller"),
+			page:        p,
+		}, nil
+	}
+	return &endpoint{
+		ID:             form.Get("id"),
+		OrganizationID: form.Get("organizationID"),
+		URL:            form.Get("url"),
+		Schema:         form.Get("schema"),
+		Action:         action,
+		caller:         form.Get("caller"),
+		page:           p,
+	}, nil
+}
+
+// exchange sends req and returns everything exchanged, however it ended.
+func (svc *service) exchange(req serviceMsg) *exchange {
+	x := startExchange(req)
+	x.request(req.toFlatBufferBytes(flatbuffers.NewBuilder(0)))
+	x.end(svc.stream(req, func(resp serviceMsg, frame []byte) error {
+		x.response(frame)
+		return nil
+	}))
+	return x
+}
+
+func writeDebugReport(w io.Writer, report debugReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// debugAuthorized checks that r carries the debug token, as a bearer token or
+// the password of basic auth so browsers can prompt for it, and that posts
+// come from the page itself.
+func (s *server) debugAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.debugToken) == 0 {
+		http.NotFound(w, r)
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.debugToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="debug"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if origin := r.Header.Get("Origin"); r.Method == http.MethodPost && len(origin) > 0 {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// debugBackendHandler shows a form for sending a request to a backend, and
+// sends the request posted, writing what was exchanged with every frame
+// decoded. It's only routed if the server has a debug token.
+func (s *server) debugBackendHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.debugAuthorized(w, r) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		w.Header().Set(contentTypeHeader, "text/html; charset=utf-8")
+		io.WriteString(w, debugBackendPage)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if len(r.PostForm.Get("caller")) == 0 {
+		r.PostForm.Set("caller", "debug")
+	}
+	req, err := debugRequest(r.PostForm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	svc := s.getService()
+	defer s.putService(svc)
+	w.Header().Set(contentTypeHeader, jsonContentTypeValue)
+	writeDebugReport(w, describeExchange(svc.exchange(req)))
+}
+
+const debugBackendPage = `<!DOCTYPE html>
+<html>
+<head><title>Backend debugger</title></head>
+<body>
+<h1>Backend debugger</h1>
+<p>Sends one request to a backend and shows every response frame decoded. Requests are sent as they are; deletes delete.</p>
+<form method="post">
+<p><label>Service <select name="service"><option>organizations</option><option>endpoints</option></select></label>
+<label>Action <input name="action" value="Read" size="8"></label> (a name or any number)</p>
+<p><label>ID <input name="id"></label>
+<label>Name <input name="name"></label>
+<label>Display name <input name="displayName"></label></p>
+<p><label>Organization <input name="organizationID"></label>
+<label>URL <input name="url" size="40"></label></p>
+<p><label>Schema<br><textarea name="schema" rows="4" cols="60"></textarea></label></p>
+<p><label>Limit <input name="limit" size="5"></label>
+<label>Cursor <input name="cursor"></label>
+<label>Sort <input name="sort" size="8"></label>
+<label>Prefix <input name="prefix"></label>
+<label>Caller <input name="caller"></label></p>
+<p><input type="submit" value="Send"></p>
+</form>
+</body>
+</html>
+`
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/flatbuffers/go"
+	"github.com/knollit/http_frontend/endpoints"
+	"github.com/knollit/http_frontend/organizations"
+	"github.com/mikeraimondi/prefixedio"
+)
+
+// frameHandler answers the request frame of a connection to a backend by
+// writing response frames to w.
+type frameHandler func(w io.Writer, frame []byte) error
+
+// localBackends serve the organization and endpoint services from this
+// process, on loopback ports.
+type localBackends struct {
+	orgListener      net.Listener
+	endpointListener net.Listener
+	tlsConf          *tls.Config
+	wg               sync.WaitGroup
+}
+
+// listen serves both services, over TLS if tlsConf isn't nil. The server's
+// certificate isn't verified by connect.
+func (lb *localBackends) listen(tlsConf *tls.Config, org, endpoint frameHandler) (err error) {
+	lb.tlsConf = tlsConf
+	if lb.orgListener, err = lb.listener(); err != nil {
+		return
+	}
+	if lb.endpointListener, err = lb.listener(); err != nil {
+		lb.orgListener.Close()
+		return
+	}
+	lb.wg.Add(2)
+	go lb.serve(lb.orgListener, org)
+	go lb.serve(lb.endpointListener, endpoint)
+	return nil
+}
+
+func (lb *localBackends) listener() (net.Listener, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil || lb.tlsConf == nil {
+		return l, err
+	}
+	return tls.NewListener(l, lb.tlsConf), nil
+}
+
+// connect makes s dial the local services.
+func (lb *localBackends) connect(s *server) {
+	dial := func(l net.Listener) func() (net.Conn, error) {
+		return func() (net.Conn, error) {
+			if lb.tlsConf != nil {
+				return tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+			}
+			return net.Dial("tcp", l.Addr().String())
+		}
+	}
+	s.getOrgSvcConn = dial(lb.orgListener)
+	s.getEndpointSvcConn = dial(lb.endpointListener)
+}
+
+// Close stops serving and waits for requests in progress.
+func (lb *localBackends) Close() error {
+	err := lb.orgListener.Close()
+	if epErr := lb.endpointListener.Close(); err == nil {
+		err = epErr
+	}
+	lb.wg.Wait()
+	return err
+}
+
+// serve answers each connection's request frame with handle, then closes it,
+// like the real services.
+func (lb *localBackends) serve(l net.Listener, handle frameHandler) {
+	defer lb.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		lb.wg.Add(1)
+		go func() {
+			defer lb.wg.Done()
+			defer conn.Close()
+			var buf prefixedio.Buffer
+			if _, err := buf.ReadFrom(conn); err != nil {
+				log.Println("local backend read error: ", err)
+				return
+			}
+			if err := handle(conn, buf.Bytes()); err != nil {
+				log.Println("local backend write error: ", err)
+			}
+		}()
+	}
+}
+
+// respond returns a frameHandler writing the messages answer returns.
+func respond(answer func(frame []byte) []serviceMsg) frameHandler {
+	return func(w io.Writer, frame []byte) error {
+		b := flatbuffers.NewBuilder(0)
+		for _, resp := range answer(frame) {
+			if _, err := prefixedio.WriteBytes(w, resp.toFlatBufferBytes(b)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// fakeBackends are in-memory organization and endpoint services speaking the
+// backends' protocol, for local development and tests. They implement every
+// action the frontend sends, but keep nothing once closed.
+type fakeBackends struct {
+	localBackends
+	mu        sync.Mutex
+	orgs      map[string]*organization // by name
+	endpoints map[string][]*endpoint   // by organization name, in creation order
+}
+
+func newFakeBackends() *fakeBackends {
+	return &fakeBackends{
+		orgs:      make(map[string]*organization),
+		endpoints: make(map[string][]*endpoint),
+	}
+}
+
+// listen serves both services, over TLS if tlsConf isn't nil.
+func (fb *fakeBackends) listen(tlsConf *tls.Config) error {
+	return fb.localBackends.listen(tlsConf, respond(fb.handleOrganization), respond(fb.handleEndpoint))
+}
+
+func newFakeID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// fakePage returns the items of an index after the one with ID cursor, up to
+// limit if it's positive. A cursor that isn't the ID of an item, such as one
+// deleted since, gets an empty page rather than the index from the start.
+func fakePage(items []serviceMsg, cursor string, limit int) []serviceMsg {
+	if len(cursor) > 0 {
+		after := len(items)
+		for i, item := range items {
+			if item.getID() == cursor {
+				after = i + 1
+				break
+			}
+		}
+		items = items[after:]
+	}
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+func (fb *fakeBackends) handleOrganization(frame []byte) []serviceMsg {
+	msg := organizations.GetRootAsOrganization(frame, 0)
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	orgErr := func(text string) []serviceMsg {
+		return []serviceMsg{&organization{err: errors.New(text)}}
+	}
+	switch msg.Action() {
+	case organizations.ActionNew:
+		name := string(msg.Name())
+		if len(name) == 0 {
+			return orgErr("name is required")
+		}
+		if _, ok := fb.orgs[name]; ok {
+			return orgErr("organization already exists")
+		}
+		org := &organization{ID: newFakeID(), Name: name, DisplayName: string(msg.DisplayName()), version: 1}
+		fb.orgs[name] = org
+		created := *org
+		return []serviceMsg{&created}
+	case organizations.ActionRead:
+		org, ok := fb.orgs[string(msg.Name())]
+		if !ok {
+			return orgErr(notFoundErrMsg)
+		}
+		found := *org
+		return []serviceMsg{&found}
+	case organizations.ActionIndex:
+		var items []serviceMsg
+		for _, org := range fb.orgs {
+			if strings.HasPrefix(org.Name, string(msg.Prefix())) {
+				found := *org
+				items = append(items, &found)
+			}
+		}
+		descending := string(msg.Sort()) == "-name"
+		sort.Slice(items, func(i, j int) bool {
+			if descending {
+				return items[i].(*organization).Name > items[j].(*organization).Name
+			}
+			return items[i].(*organization).Name < items[j].(*organization).Name
+		})
+		return fakePage(items, string(msg.Cursor()), int(msg.Limit()))
+	case organizations.ActionUpdate, organizations.ActionDelete:
+		var org *organization
+		for _, o := range fb.orgs {
+			if o.ID == string(msg.ID()) {
+				org = o
+			}
+		}
+		if org == nil {
+			return orgErr(notFoundErrMsg)
+		}
+		if v := msg.Version(); v > 0 && v != org.version {
+			return orgErr(versionConflictErrMsg)
+		}
+		if msg.Action() == organizations.ActionDelete {
+			delete(fb.orgs, org.Name)
+			delete(fb.endpoints, org.Name)
+			deleted := *org
+			return []serviceMsg{&deleted}
+		}
+		if name := string(msg.Name()); name != org.Name {
+			if _, ok := fb.orgs[name]; ok || len(name) == 0 {
+				return orgErr("organization already exists")
+			}
+			delete(fb.orgs, org.Name)
+			fb.orgs[name] = org
+			fb.endpoints[name] = fb.endpoints[org.Name]
+			delete(fb.endpoints, org.Name)
+			for _, e := range fb.endpoints[name] {
+				e.OrganizationID = name
+			}
+			org.Name = name
+		}
+		org.DisplayName = string(msg.DisplayName())
+		org.version++
+		updated := *org
+		return []serviceMsg{&updated}
+	}
+	return orgErr(fmt.Sprintf("unknown action %v", msg.Action()))
+}
+
+func (fb *fakeBackends) handleEndpoint(frame []byte) []serviceMsg {
+	msg := endpoints.GetRootAsEndpoint(frame, 0)
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	orgName := string(msg.OrganizationID())
+	endpointErr := func(text string) *endpoint {
+		return &endpoint{err: errors.New(text)}
+	}
+	if _, ok := fb.orgs[orgName]; !ok {
+		return []serviceMsg{endpointErr("organization not found")}
+	}
+	find := func(id string) int {
+		for i, e := range fb.endpoints[orgName] {
+			if e.ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+	conflict := func(i int) bool {
+		v := msg.Version()
+		return v > 0 && v != fb.endpoints[orgName][i].version
+	}
+	switch msg.Action() {
+	case endpoints.ActionNew:
+		if len(msg.URL()) == 0 {
+			return []serviceMsg{endpointErr("url is required")}
+		}
+		e := &endpoint{ID: newFakeID(), OrganizationID: orgName, URL: string(msg.URL()), Schema: string(msg.Schema()), version: 1}
+		fb.endpoints[orgName] = append(fb.endpoints[orgName], e)
+		created := *e
+		return []serviceMsg{&created}
+	case endpoints.ActionRead:
+		i := find(string(msg.Id()))
+		if i < 0 {
+			return []serviceMsg{endpointErr(notFoundErrMsg)}
+		}
+		found := *fb.endpoints[orgName][i]
+		return []serviceMsg{&found}
+	case endpoints.ActionIndex:
+		var items []serviceMsg
+		for _, e := range fb.endpoints[orgName] {
+			if strings.HasPrefix(e.URL, string(msg.Prefix())) {
+				found := *e
+				items = append(items, &found)
+			}
+		}
+		switch string(msg.Sort()) {
+		case "url":
+			sort.SliceStable(items, func(i, j int) bool { return items[i].(*endpoint).URL < items[j].(*endpoint).URL })
+		case "-url":
+			sort.SliceStable(items, func(i, j int) bool { return items[i].(*endpoint).URL > items[j].(*endpoint).URL })
+		}
+		return fakePage(items, string(msg.Cursor()), int(msg.Limit()))
+	case endpoints.ActionUpdate:
+		i := find(string(msg.Id()))
+		if i < 0 {
+			return []serviceMsg{endpointErr(notFoundErrMsg)}
+		}
+		if conflict(i) {
+			return []serviceMsg{endpointErr(versionConflictErrMsg)}
+		}
+		e := fb.endpoints[orgName][i]
+		e.URL = string(msg.URL())
+		e.Schema = string(msg.Schema())
+		e.version++
+		updated := *e
+		return []serviceMsg{&updated}
+	case endpoints.ActionDelete:
+		i := find(string(msg.Id()))
+		if i < 0 {
+			return []serviceMsg{endpointErr(notFoundErrMsg)}
+		}
+		if conflict(i) {
+			return []serviceMsg{endpointErr(versionConflictErrMsg)}
+		}
+		deleted := *fb.endpoints[orgName][i]
+		fb.endpoints[orgName] = append(fb.endpoints[orgName][:i], fb.endpoints[orgName][i+1:]...)
+		return []serviceMsg{&deleted}
+	}
+	return []serviceMsg{endpointErr(fmt.Sprintf("unknown action %v", msg.Action()))}
+}
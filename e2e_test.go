@@ -78,7 +78,9 @@ func TestOrganizationIndexE2E(t *testing.T) {
 		resp.Body.Close()
 
 		assertGet(t, orgURL, []organization{organization{
-			Name: orgName,
+			Name:        "testorg",
+			DisplayName: orgName,
+			Self:        "/organizations/testorg",
 		},
 		})
 	})
@@ -101,7 +103,7 @@ func TestEndpointReadE2E(t *testing.T) {
 
 		// setup: create endpoint
 		endpointURL := fmt.Sprintf("http://%s%v/organizations/%v/endpoints", ip, port, orgName)
-		resp, err = http.PostForm(endpointURL, url.Values{"url": {"some url"}})
+		resp, err = http.PostForm(endpointURL, url.Values{"url": {"http://example.com"}})
 		if err != nil {
 			t.Fatal(err)
 		}
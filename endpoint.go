@@ -3,20 +3,31 @@ package main
 import (
 	"errors"
 	"net"
+	"net/http"
+	"net/url"
 
 	"github.com/google/flatbuffers/go"
 	"github.com/knollit/http_frontend/endpoints"
 )
 
-const notFoundErrMsg = "not found"
+const (
+	notFoundErrMsg        = "not found"
+	versionConflictErrMsg = "version conflict"
+)
 
 type endpoint struct {
 	ID             string
 	OrganizationID string
 	URL            string
 	Schema         string
-	Action         int8 `json:"-"`
+	Self           string `json:"self" msgpack:"self"`
+	Action         int8   `json:"-" msgpack:"-"`
+	caller         string
+	version        uint64
+	page           page
 	err            error
+	// frame is the backend frame the endpoint was decoded from, if any.
+	frame []byte
 }
 
 func (e *endpoint) new() serviceMsg {
@@ -27,6 +38,37 @@ func (e *endpoint) getConn(s *server) (net.Conn, error) {
 	return s.getEndpointSvcConn()
 }
 
+func (e *endpoint) getID() string {
+	return e.ID
+}
+
+func (e *endpoint) getErr() error {
+	return e.err
+}
+
+func (e *endpoint) getFrame() []byte {
+	return e.frame
+}
+
+func (e *endpoint) setFrame(frame []byte) {
+	e.frame = frame
+}
+
+// idempotent reports whether the endpoint service can safely be sent the
+// request twice.
+func (e *endpoint) idempotent() bool {
+	return e.Action == endpoints.ActionRead || e.Action == endpoints.ActionIndex
+}
+
+// setSelf sets and returns the canonical URL of the endpoint within the
+// organization of r.
+func (e *endpoint) setSelf(r *http.Request) string {
+	if len(e.ID) > 0 {
+		e.Self = (&url.URL{Path: requestAPIVersion(r).prefix + "/organizations/" + requestOrganizationName(r) + "/endpoints/" + e.ID}).String()
+	}
+	return e.Self
+}
+
 func (e *endpoint) fromBytes(bytes []byte) {
 	e.fromFlatBufferMsg(endpoints.GetRootAsEndpoint(bytes, 0))
 }
@@ -35,6 +77,8 @@ func (e *endpoint) fromFlatBufferMsg(msg *endpoints.Endpoint) {
 	e.URL = string(msg.URL())
 	e.ID = string(msg.Id())
 	e.OrganizationID = string(msg.OrganizationID())
+	e.Schema = string(msg.Schema())
+	e.version = msg.Version()
 	if len(msg.Error()) > 0 {
 		e.err = errors.New(string(msg.Error()))
 	}
@@ -47,6 +91,10 @@ func (e *endpoint) toFlatBufferBytes(b *flatbuffers.Builder) []byte {
 	orgPosition := b.CreateByteString([]byte(e.OrganizationID))
 	urlPosition := b.CreateByteString([]byte(e.URL))
 	schemaPosition := b.CreateByteString([]byte(e.Schema))
+	callerPosition := b.CreateByteString([]byte(e.caller))
+	cursorPosition := b.CreateByteString([]byte(e.page.after))
+	sortPosition := b.CreateByteString([]byte(e.page.sort))
+	prefixPosition := b.CreateByteString([]byte(e.page.prefix))
 	var errPosition flatbuffers.UOffsetT
 	if e.err != nil {
 		errPosition = b.CreateByteString([]byte(e.err.Error()))
@@ -58,6 +106,16 @@ func (e *endpoint) toFlatBufferBytes(b *flatbuffers.Builder) []byte {
 	endpoints.EndpointAddOrganizationID(b, orgPosition)
 	endpoints.EndpointAddURL(b, urlPosition)
 	endpoints.EndpointAddSchema(b, schemaPosition)
+	endpoints.EndpointAddCaller(b, callerPosition)
+	if e.page.limit > 0 {
+		endpoints.EndpointAddLimit(b, int32(e.page.limit+1))
+	}
+	endpoints.EndpointAddCursor(b, cursorPosition)
+	endpoints.EndpointAddSort(b, sortPosition)
+	endpoints.EndpointAddPrefix(b, prefixPosition)
+	if e.version > 0 {
+		endpoints.EndpointAddVersion(b, e.version)
+	}
 	if e.err != nil {
 		endpoints.EndpointAddError(b, errPosition)
 	}
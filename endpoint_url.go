@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// lookupTimeout bounds resolving the host of an endpoint URL.
+const lookupTimeout = 5 * time.Second
+
+var (
+	errURLInvalid        = errors.New("is not a valid URL")
+	errURLNotAbsolute    = errors.New("must be an absolute URL")
+	errURLScheme         = errors.New("must use the http or https scheme")
+	errURLUserinfo       = errors.New("must not contain a username or password")
+	errURLNoHost         = errors.New("must have a host")
+	errURLUnresolvable   = errors.New("host could not be resolved")
+	errURLPrivateAddress = errors.New("must not point at a private or loopback address")
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// privateNetworks are the ranges endpoint URLs may not point into unless
+// private addresses are allowed.
+var privateNetworks = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+func parseCIDRs(cidrs ...string) (networks []*net.IPNet) {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlPolicy decides which endpoint URLs are accepted.
+type urlPolicy struct {
+	// rejectPrivate rejects URLs whose host is, or resolves to, a private or
+	// loopback address, so the backends can't be pointed at internal
+	// services.
+	rejectPrivate bool
+	lookupIP      func(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// lookup resolves host, or returns it if it's an IP address.
+func (p urlPolicy) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if p.lookupIP != nil {
+		return p.lookupIP(ctx, host)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, err
+}
+
+// check returns the addresses of host if the policy allows them all.
+func (p urlPolicy) check(ctx context.Context, host string) ([]net.IP, error) {
+	ips, err := p.lookup(ctx, host)
+	if err != nil || len(ips) == 0 {
+		return nil, errURLUnresolvable
+	}
+	if p.rejectPrivate {
+		for _, ip := range ips {
+			if isPrivateIP(ip) {
+				return nil, errURLPrivateAddress
+			}
+		}
+	}
+	return ips, nil
+}
+
+// normalize checks raw against the policy and returns its normal form: an
+// absolute http or https URL with a lowercase scheme and host, no default
+// port, no fragment and at least a "/" path. Resolving the host is abandoned
+// if ctx is done.
+func (p urlPolicy) normalize(ctx context.Context, raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", errURLInvalid
+	}
+	if !u.IsAbs() {
+		return "", errURLNotAbsolute
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, ok := defaultPorts[u.Scheme]; !ok {
+		return "", errURLScheme
+	}
+	if u.User != nil {
+		return "", errURLUserinfo
+	}
+	host := strings.ToLower(u.Hostname())
+	if len(host) == 0 {
+		return "", errURLNoHost
+	}
+	if port := u.Port(); len(port) > 0 && port != defaultPorts[u.Scheme] {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+	u.Fragment = ""
+	if len(u.Path) == 0 {
+		u.Path = "/"
+	}
+
+	if p.rejectPrivate {
+		ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+		defer cancel()
+		if _, err := p.check(ctx, host); err != nil {
+			return "", err
+		}
+	}
+	return u.String(), nil
+}
@@ -1,21 +1,63 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"net/http"
+	"sort"
+	"strings"
 )
 
-type httpMethods map[string]struct{}
+// httpMethods are the methods a route serves. Routes serving GET also serve
+// HEAD, and every route answers OPTIONS with the methods it allows.
+type httpMethods []string
 
-func (methods httpMethods) permit(method string, w http.ResponseWriter) bool {
-	if _, ok := methods[method]; !ok {
-		buf := bytes.Buffer{}
-		for m := range methods {
-			buf.WriteString(m)
+func (methods httpMethods) serves(method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed returns the Allow header of a route serving methods.
+func (methods httpMethods) allowed() string {
+	allowed := append([]string{http.MethodOptions}, methods...)
+	if methods.serves(http.MethodGet) && !methods.serves(http.MethodHead) {
+		allowed = append(allowed, http.MethodHead)
+	}
+	sort.Strings(allowed)
+	return strings.Join(allowed, ", ")
+}
+
+type headRequestContextKey struct{}
+
+// isHead reports whether r is a HEAD request passed on as GET by route.
+func isHead(r *http.Request) bool {
+	head, _ := r.Context().Value(headRequestContextKey{}).(bool)
+	return head
+}
+
+// route passes requests for methods to next, as GET requests if they're
+// HEAD. The server discards what next writes to the body of a HEAD response,
+// and handlers that would do a lot of work for it can check isHead. route
+// answers OPTIONS itself, and any other method with 405.
+func (methods httpMethods) route(next http.HandlerFunc) http.HandlerFunc {
+	allow := methods.allowed()
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case methods.serves(r.Method):
+			next(w, r)
+		case r.Method == http.MethodHead && methods.serves(http.MethodGet):
+			get := r.WithContext(context.WithValue(r.Context(), headRequestContextKey{}, true))
+			get.Method = http.MethodGet
+			next(w, get)
+		case r.Method == http.MethodOptions:
+			w.Header().Set("Allow", allow)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", allow)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
-		w.Header().Set("Allow", buf.String())
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return false
 	}
-	return true
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// storedResponse is a response recorded for an Idempotency-Key, along with a
+// fingerprint of the request that produced it.
+type storedResponse struct {
+	fingerprint [sha256.Size]byte
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+// idempotencyStore keeps responses to requests carrying an Idempotency-Key
+// so retries can be answered without repeating the request. It also tracks
+// the keys of requests in flight, so one store shared by every route, and
+// every API version of a route, runs each key once.
+type idempotencyStore interface {
+	get(key string) (*storedResponse, bool)
+	put(key string, resp *storedResponse, ttl time.Duration)
+	// reserve marks key in flight and returns true, unless it already is
+	// or has a stored response, which it returns instead.
+	reserve(key string) (*storedResponse, bool)
+	// release ends the reservation of key.
+	release(key string)
+}
+
+type memoryIdempotencyEntry struct {
+	resp    *storedResponse
+	expires time.Time
+}
+
+// memoryIdempotencyStore is an idempotencyStore local to one frontend
+// process.
+type memoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryIdempotencyEntry
+	inFlight  map[string]struct{}
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{
+		entries:  make(map[string]memoryIdempotencyEntry),
+		inFlight: make(map[string]struct{}),
+		now:      time.Now,
+	}
+}
+
+func (store *memoryIdempotencyStore) get(key string) (*storedResponse, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.lookup(key)
+}
+
+// lookup returns the unexpired response stored for key. The caller must
+// hold the lock.
+func (store *memoryIdempotencyStore) lookup(key string) (*storedResponse, bool) {
+	entry, ok := store.entries[key]
+	if !ok || store.now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.resp, true
+}
+
+func (store *memoryIdempotencyStore) reserve(key string) (*storedResponse, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if stored, ok := store.lookup(key); ok {
+		return stored, false
+	}
+	if _, ok := store.inFlight[key]; ok {
+		return nil, false
+	}
+	store.inFlight[key] = struct{}{}
+	return nil, true
+}
+
+func (store *memoryIdempotencyStore) release(key string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.inFlight, key)
+}
+
+func (store *memoryIdempotencyStore) put(key string, resp *storedResponse, ttl time.Duration) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := store.now()
+	if now.Sub(store.lastSweep) > time.Minute {
+		for k, entry := range store.entries {
+			if now.After(entry.expires) {
+				delete(store.entries, k)
+			}
+		}
+		store.lastSweep = now
+	}
+	store.entries[key] = memoryIdempotencyEntry{resp: resp, expires: now.Add(ttl)}
+}
+
+// responseRecorder passes a response through to the client while keeping a
+// copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// idempotent replays the stored response to a POST whose Idempotency-Key has
+// been seen before, instead of calling next again. Reusing a key with a
+// different request is rejected, as is a retry that arrives while the first
+// request is still in flight. Server errors aren't stored, so they can be
+// retried.
+func (s *server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || len(key) == 0 || s.idempotencyStore == nil {
+			next(w, r)
+			return
+		}
+		// Keys are scoped to the client, so anonymous clients don't share
+		// them.
+		key = clientKey(r) + " " + key
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h := sha256.New()
+		io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+		h.Write(body)
+		var fingerprint [sha256.Size]byte
+		copy(fingerprint[:], h.Sum(nil))
+
+		stored, reserved := s.idempotencyStore.reserve(key)
+		if stored != nil {
+			if stored.fingerprint != fingerprint {
+				http.Error(w, "Idempotency-Key was used for a different request", http.StatusUnprocessableEntity)
+				return
+			}
+			for k, v := range stored.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.status)
+			w.Write(stored.body)
+			return
+		}
+		if !reserved {
+			http.Error(w, "a request with this Idempotency-Key is in progress", http.StatusConflict)
+			return
+		}
+		defer s.idempotencyStore.release(key)
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			return
+		}
+		s.idempotencyStore.put(key, &storedResponse{
+			fingerprint: fingerprint,
+			status:      rec.status,
+			header:      replayHeader(w.Header()),
+			body:        rec.body.Bytes(),
+		}, s.idempotencyTTL)
+	}
+}
+
+// replayHeader returns the headers of a response to store for replays. The
+// rate limit headers describe the first request, not the retry. The body is
+// recorded before the compression middleware encodes it, so the encoding it
+// chose for the first client is dropped, along with the entity tag marking
+// it; replays are encoded for the client retrying.
+func replayHeader(h http.Header) http.Header {
+	header := make(http.Header)
+	for k, v := range h {
+		switch {
+		case strings.HasPrefix(k, "Ratelimit-"), k == "Content-Encoding", k == "Content-Length", k == "Vary":
+		case k == "Etag":
+			header[k] = []string{decodedETag(h.Get(k))}
+		default:
+			header[k] = v
+		}
+	}
+	return header
+}
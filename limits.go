@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultMaxBodySize applies to routes without a body limit of their own.
+	defaultMaxBodySize = 64 << 10
+	defaultMaxInFlight = 256
+	defaultMaxWatches  = 1024
+
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+// defaultBodyLimits are the largest request bodies accepted, in bytes, by
+// route path template. Endpoint bodies carry schemas, so they may be larger,
+// and batches and imports carry many endpoints.
+var defaultBodyLimits = map[string]int64{
+	"/organizations":                                           4 << 10,
+	"/organizations/{organizationName}":                        4 << 10,
+	"/organizations/{organizationName}/endpoints":              1 << 20,
+	"/organizations/{organizationName}/endpoints/{endpointID}": 1 << 20,
+	"/organizations/{organizationName}/endpoints:batch":        8 << 20,
+	"/organizations/{organizationName}/import":                 32 << 20,
+}
+
+// watchRoutes are the route path templates of the indexes that can be
+// watched.
+var watchRoutes = map[string]bool{
+	"/organizations": true,
+	"/organizations/{organizationName}/endpoints": true,
+}
+
+// limitBody reads the request body before passing it to next, responding 413
+// instead if it's larger than the body limit of path. It runs inside shed,
+// so at most one body per in-flight slot is held in memory, and requests
+// that are shed aren't read at all.
+func (s *server) limitBody(path string, next http.HandlerFunc) http.HandlerFunc {
+	limit, ok := s.bodyLimits[path]
+	if !ok {
+		limit = s.maxBodySize
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.Body != nil {
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+			if err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > limit {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		next(w, r)
+	}
+}
+
+// shed caps the number of requests to path in flight to the backends at the
+// capacity of s.inFlight, responding 503 to requests beyond it rather than
+// queueing them. Watches are long-lived and mostly idle, so they're capped
+// separately, at the capacity of s.watching.
+func (s *server) shed(path string, next http.HandlerFunc) http.HandlerFunc {
+	watchable := watchRoutes[path]
+	return func(w http.ResponseWriter, r *http.Request) {
+		slots := s.inFlight
+		if watchable && r.Method == http.MethodGet && !isHead(r) && negotiate(r, listMediaTypes...) == eventStreamMediaType {
+			slots = s.watching
+		}
+		if slots == nil {
+			next(w, r)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "server busy", http.StatusServiceUnavailable)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/google/flatbuffers/go"
+	"github.com/mikeraimondi/prefixedio"
+	"gopkg.in/vmihailenco/msgpack.v2"
+)
+
+// flushEvery is how many listed items are written between flushes.
+const flushEvery = 50
+
+// listWriter writes the items of an index to the client one at a time.
+type listWriter interface {
+	// item writes v, the representation of a message decoded from the
+	// backend's frame.
+	item(v interface{}, frame []byte) error
+	// close ends the listing, passing on the cursor of the next page, if
+	// any, and reporting err if the backend failed mid-stream.
+	close(next string, err error) error
+	written() int
+}
+
+// newListWriter returns a listWriter for mediaType and sets the matching
+// Content-Type, or returns nil if the media type can't be listed.
+func newListWriter(w http.ResponseWriter, r *http.Request, mediaType string) listWriter {
+	switch mediaType {
+	case jsonMediaType:
+		w.Header().Set(contentTypeHeader, jsonContentTypeValue)
+		return &jsonListWriter{w: w, envelope: requestAPIVersion(r).envelope}
+	case ndjsonMediaType:
+		w.Header().Set(contentTypeHeader, ndjsonMediaType)
+		return &ndjsonListWriter{w: w}
+	case msgpackMediaType:
+		w.Header().Set(contentTypeHeader, msgpackMediaType)
+		return &msgpackListWriter{w: w}
+	case flatbuffersMediaType:
+		w.Header().Set(contentTypeHeader, flatbuffersMediaType)
+		return &flatbuffersListWriter{w: w}
+	}
+	return nil
+}
+
+// jsonListWriter writes a JSON array one element at a time, optionally
+// enveloped in an object.
+type jsonListWriter struct {
+	w        io.Writer
+	n        int
+	envelope bool
+}
+
+func (lw *jsonListWriter) item(v interface{}, frame []byte) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if lw.n == 0 {
+		sep = lw.open()
+	}
+	if _, err = io.WriteString(lw.w, sep); err != nil {
+		return err
+	}
+	if _, err = lw.w.Write(data); err != nil {
+		return err
+	}
+	lw.n++
+	flushPeriodically(lw.w, lw.n)
+	return nil
+}
+
+func (lw *jsonListWriter) open() string {
+	if lw.envelope {
+		return `{"data":[`
+	}
+	return "["
+}
+
+// close ends the array. If err is not nil, it is described by the envelope's
+// "error" member, or by an object appended as the array's last element.
+func (lw *jsonListWriter) close(next string, err error) error {
+	if lw.n == 0 {
+		io.WriteString(lw.w, lw.open())
+	}
+	if !lw.envelope {
+		if err != nil {
+			if lw.n > 0 {
+				io.WriteString(lw.w, ",")
+			}
+			data, _ := json.Marshal(map[string]string{"error": err.Error()})
+			lw.w.Write(data)
+		}
+		_, err = io.WriteString(lw.w, "]\n")
+		return err
+	}
+
+	io.WriteString(lw.w, "]")
+	if len(next) > 0 {
+		data, _ := json.Marshal(next)
+		fmt.Fprintf(lw.w, `,"next":%s`, data)
+	}
+	if err != nil {
+		data, _ := json.Marshal(err.Error())
+		fmt.Fprintf(lw.w, `,"error":%s`, data)
+	}
+	_, err = io.WriteString(lw.w, "}\n")
+	return err
+}
+
+func (lw *jsonListWriter) written() int {
+	return lw.n
+}
+
+// ndjsonListWriter writes one JSON object per line.
+type ndjsonListWriter struct {
+	w io.Writer
+	n int
+}
+
+func (lw *ndjsonListWriter) item(v interface{}, frame []byte) error {
+	if err := json.NewEncoder(lw.w).Encode(v); err != nil {
+		return err
+	}
+	lw.n++
+	flushPeriodically(lw.w, lw.n)
+	return nil
+}
+
+// close writes a final line describing err, if any.
+func (lw *ndjsonListWriter) close(next string, err error) error {
+	if err != nil {
+		return json.NewEncoder(lw.w).Encode(map[string]string{"error": err.Error()})
+	}
+	return nil
+}
+
+func (lw *ndjsonListWriter) written() int {
+	return lw.n
+}
+
+// msgpackListWriter writes a stream of consecutive MessagePack maps, since an
+// array would need its length up front.
+type msgpackListWriter struct {
+	w io.Writer
+	n int
+}
+
+func (lw *msgpackListWriter) item(v interface{}, frame []byte) error {
+	if err := msgpack.NewEncoder(lw.w).Encode(v); err != nil {
+		return err
+	}
+	lw.n++
+	flushPeriodically(lw.w, lw.n)
+	return nil
+}
+
+// close writes a final map describing err, if any.
+func (lw *msgpackListWriter) close(next string, err error) error {
+	if err != nil {
+		return msgpack.NewEncoder(lw.w).Encode(map[string]string{"error": err.Error()})
+	}
+	return nil
+}
+
+func (lw *msgpackListWriter) written() int {
+	return lw.n
+}
+
+// flatbuffersListWriter passes the backend's frames through unchanged, each
+// prefixed with its length as on the backend connection. A failure is only
+// reported in the Stream-Error trailer.
+type flatbuffersListWriter struct {
+	w io.Writer
+	n int
+}
+
+func (lw *flatbuffersListWriter) item(v interface{}, frame []byte) error {
+	if _, err := prefixedio.WriteBytes(lw.w, frame); err != nil {
+		return err
+	}
+	lw.n++
+	flushPeriodically(lw.w, lw.n)
+	return nil
+}
+
+func (lw *flatbuffersListWriter) close(next string, err error) error {
+	return nil
+}
+
+func (lw *flatbuffersListWriter) written() int {
+	return lw.n
+}
+
+func flushPeriodically(w io.Writer, n int) {
+	if f, ok := w.(http.Flusher); ok && n%flushEvery == 0 {
+		f.Flush()
+	}
+}
+
+// resourceMediaTypes are the formats single resources can be written in.
+var resourceMediaTypes = []string{jsonMediaType, msgpackMediaType, flatbuffersMediaType}
+
+// acceptable reports whether the client accepts a format resources can be
+// written in, responding 406 if not. Handlers check it before sending
+// anything to the backends, so a change isn't applied only for its result
+// to be refused.
+func acceptable(w http.ResponseWriter, r *http.Request) bool {
+	if len(negotiate(r, resourceMediaTypes...)) == 0 {
+		http.Error(w, "not acceptable", http.StatusNotAcceptable)
+		return false
+	}
+	return true
+}
+
+// writeResource writes msg to the client in the format it asked for. A
+// created resource's canonical URL is sent in the Location header. Clients
+// asking for flatbuffers get the backend's frame unchanged, if msg kept it.
+func writeResource(w http.ResponseWriter, r *http.Request, b *flatbuffers.Builder, status int, msg serviceMsg) {
+	v := represent(r, msg)
+	if status == http.StatusCreated {
+		w.Header().Set("Location", msg.setSelf(r))
+	}
+	switch mediaType := negotiate(r, resourceMediaTypes...); mediaType {
+	case jsonMediaType:
+		w.Header().Set(contentTypeHeader, jsonContentTypeValue)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	case msgpackMediaType:
+		w.Header().Set(contentTypeHeader, msgpackMediaType)
+		w.WriteHeader(status)
+		msgpack.NewEncoder(w).Encode(v)
+	case flatbuffersMediaType:
+		frame := msg.getFrame()
+		if frame == nil {
+			frame = msg.toFlatBufferBytes(b)
+		}
+		w.Header().Set(contentTypeHeader, flatbuffersMediaType)
+		w.WriteHeader(status)
+		prefixedio.WriteBytes(w, frame)
+	default:
+		http.Error(w, "not acceptable", http.StatusNotAcceptable)
+	}
+}
+
+// listMediaTypes are the formats indexes can be listed in.
+var listMediaTypes = []string{jsonMediaType, ndjsonMediaType, eventStreamMediaType, msgpackMediaType, flatbuffersMediaType}
+
+// indexItem is an item of an index and the backend frame it was decoded
+// from.
+type indexItem struct {
+	msg   serviceMsg
+	frame []byte
+}
+
+// errListingInterrupted is what clients are told when the backend fails part
+// way through a listing. The backend's error is logged instead.
+var errListingInterrupted = errors.New("listing interrupted")
+
+// streamIndex sends an Index request and writes up to limit of the responses
+// to the client as they arrive, in the format it asked for. Since the body is
+// under way by the time the frontend knows whether another page follows, the
+// Link to it is sent as a trailer, and in the envelope's "next" member if the
+// API version has one. If the backend fails part way through, the failure is
+// reported in the Stream-Error trailer and at the end of the list. HEAD
+// requests aren't sent to the backend, so their responses have no Link, and
+// can't watch.
+func (s *server) streamIndex(w http.ResponseWriter, r *http.Request, svc *service, req serviceMsg, limit int) {
+	mediaType := negotiate(r, listMediaTypes...)
+	if isHead(r) {
+		if mediaType == eventStreamMediaType {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "watches can't be requested with HEAD", http.StatusMethodNotAllowed)
+		} else if newListWriter(w, r, mediaType) == nil {
+			http.Error(w, "not acceptable", http.StatusNotAcceptable)
+		}
+		return
+	}
+	if mediaType == eventStreamMediaType {
+		s.watchIndex(w, r, req, limit)
+		return
+	}
+	lw := newListWriter(w, r, mediaType)
+	if lw == nil {
+		http.Error(w, "not acceptable", http.StatusNotAcceptable)
+		return
+	}
+	w.Header().Set("Trailer", "Link, Stream-Error")
+
+	var last serviceMsg
+	var next string
+	rejected := false
+	err := svc.stream(req, func(resp serviceMsg, frame []byte) error {
+		if err := resp.getErr(); err != nil {
+			if lw.written() == 0 {
+				rejected = true
+				w.Header().Del("Trailer")
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return errStopStream
+			}
+			return err
+		}
+		if lw.written() == limit {
+			next = nextLink(w, r, last.getID())
+			return errStopStream
+		}
+		last = resp
+		return lw.item(represent(r, resp), frame)
+	})
+	if rejected {
+		return
+	}
+	if err != nil {
+		log.Printf("index request error %v", err)
+		if lw.written() == 0 {
+			w.Header().Del("Trailer")
+			http.Error(w, "internal application error", http.StatusInternalServerError)
+			return
+		}
+		err = errListingInterrupted
+		w.Header().Set("Stream-Error", err.Error())
+	}
+	lw.close(next, err)
+}
+
+// watchIndex streams an index as server-sent events. The current items are
+// sent as "created" events, then differences found by polling the backend
+// every watchInterval are sent as "created", "updated" and "deleted" events
+// until the client goes away. Watches of the same query share a poller.
+func (s *server) watchIndex(w http.ResponseWriter, r *http.Request, req serviceMsg, limit int) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, unwatch := s.watch(req, limit)
+	defer unwatch()
+	w.Header().Set(contentTypeHeader, eventStreamMediaType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case batch, ok := <-events:
+			if !ok {
+				data, _ := json.Marshal(map[string]string{"error": "watch fell behind"})
+				writeEvent(w, "error", data)
+				f.Flush()
+				return
+			}
+			for _, event := range batch {
+				if event.err != nil {
+					log.Printf("watch request error %v", event.err)
+					data, _ := json.Marshal(map[string]string{"error": "watch interrupted"})
+					writeEvent(w, "error", data)
+					f.Flush()
+					return
+				}
+				msg := req.new()
+				msg.fromBytes(event.frame)
+				data, err := json.Marshal(represent(r, msg))
+				if err != nil {
+					return
+				}
+				if err := writeEvent(w, event.name, data); err != nil {
+					return
+				}
+			}
+			f.Flush()
+		}
+	}
+}
+
+func writeEvent(w io.Writer, event string, data []byte) error {
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"crypto/tls"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -10,8 +10,10 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/knollit/http_frontend/endpoints"
@@ -21,18 +23,67 @@ import (
 var (
 	certPath = flag.String("cert-path", os.Getenv("TLS_CERT_PATH"), "Path to cert file")
 	keyPath  = flag.String("key-path", os.Getenv("TLS_KEY_PATH"), "Path to private key file")
+	addr     = flag.String("addr", ":80", "Address to listen on")
+
+	devBackends = flag.Bool("dev-backends", false, "Serve from in-memory organization and endpoint services instead of the backends, for local development. Nothing is persisted")
+
+	recordPath     = flag.String("record-backends", "", "File to append every request to the backends and their responses to, for debugging")
+	replayPath     = flag.String("replay-backends", "", "Recording to serve in place of the backends, from -record-backends")
+	replayRealtime = flag.Bool("replay-realtime", false, "Delay replayed responses as long as they took when recorded")
+	debugToken     = flag.String("debug-token", os.Getenv("DEBUG_TOKEN"), "Token enabling /debug/backend, sent as a bearer token or basic auth password. The page is off without one")
+
+	apiKeysPath    = flag.String("api-keys", os.Getenv("API_KEYS_PATH"), "JSON file of the callers API keys authenticate, keyed by the SHA-256 hash of each key")
+	membersPath    = flag.String("members", os.Getenv("MEMBERS_PATH"), "JSON file the roles organization admins grant are saved to. They're lost on restart without one")
+	allowAnonymous = flag.Bool("allow-anonymous", os.Getenv("ALLOW_ANONYMOUS") == "true", "Serve without authentication or authorization, for local development")
+
+	idempotencyTTL   = flag.Duration("idempotency-ttl", defaultIdempotencyTTL, "How long responses to requests with an Idempotency-Key are kept")
+	allowPrivateURLs = flag.Bool("allow-private-endpoint-urls", false, "Accept endpoint URLs pointing at private or loopback addresses")
+
+	corsOrigins     = flag.String("cors-origins", os.Getenv("CORS_ORIGINS"), "Comma-separated origins browsers may call the API from, like https://*.knoll.it")
+	corsMethods     = flag.String("cors-methods", strings.Join(defaultCORSMethods, ","), "Comma-separated methods allowed in cross-origin requests")
+	corsHeaders     = flag.String("cors-headers", strings.Join(defaultCORSHeaders, ","), "Comma-separated headers allowed in cross-origin requests")
+	corsCredentials = flag.Bool("cors-credentials", false, "Allow cross-origin requests with credentials")
+	corsMaxAge      = flag.Duration("cors-max-age", 10*time.Minute, "How long browsers may cache preflight responses")
+
+	compressionMinSize = flag.Int("compression-min-size", defaultCompressionMinSize, "Smallest response body compressed, in bytes, or -1 to disable compression")
+	compressionTypes   = flag.String("compression-types", strings.Join(defaultCompressionTypes, ","), "Comma-separated media types of responses that are compressed")
+
+	maxBodySize       = flag.Int64("max-body-size", defaultMaxBodySize, "Largest request body accepted on routes without a limit of their own, in bytes")
+	maxInFlight       = flag.Int("max-in-flight", defaultMaxInFlight, "Most requests handled at once before responding 503, or 0 for no limit")
+	maxWatches        = flag.Int("max-watches", defaultMaxWatches, "Most watches open at once before responding 503, or 0 for no limit")
+	readHeaderTimeout = flag.Duration("read-header-timeout", defaultReadHeaderTimeout, "How long clients may take to send request headers")
+	readTimeout       = flag.Duration("read-timeout", defaultReadTimeout, "How long clients may take to send a whole request")
+	writeTimeout      = flag.Duration("write-timeout", 0, "How long writing a response may take, or 0 for no limit. Watches and long listings stream for longer than most timeouts")
+	idleTimeout       = flag.Duration("idle-timeout", defaultIdleTimeout, "How long idle keep-alive connections are kept open")
 )
 
 const (
 	contentTypeHeader    = "Content-Type"
 	jsonContentTypeValue = "application/json; charset=utf-8"
+
+	// defaultWatchInterval is how often watched indexes poll the backends.
+	defaultWatchInterval = 5 * time.Second
 )
 
 func main() {
+	env := &commandEnv{stdin: os.Stdin, stdout: os.Stdout, connect: connectBackends}
+	args := os.Args[1:]
+	// Without a subcommand, the binary serves the API, as it always has.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		args = append([]string{"serve"}, args...)
+	}
+	if err := runCommand(env, args); err != nil && err != flag.ErrHelp {
+		log.Fatal(err)
+	}
+}
+
+// connectBackends returns a server whose services dial the backends over
+// TLS, presenting the client cert at certPath and keyPath.
+func connectBackends(certPath, keyPath string) (*server, error) {
 	// Load client cert
-	cert, err := tls.LoadX509KeyPair(*certPath, *keyPath)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
 	if err != nil {
-		log.Fatal("Failed to open client cert and/or key: ", err)
+		return nil, fmt.Errorf("failed to open client cert and/or key: %v", err)
 	}
 
 	tlsConf := &tls.Config{
@@ -49,6 +100,115 @@ func main() {
 		// TODO what if multiple goroutines call this?
 		return tls.Dial("tcp", fmt.Sprintf("%v:13800", os.Getenv("ENDPOINTSVC_PORT_13800_TCP_ADDR")), tlsConf)
 	}
+	return s, nil
+}
+
+// serveCommand runs the server, configured by the flags of the command line.
+func serveCommand(env *commandEnv, args []string) error {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	var s *server
+	if *devBackends {
+		fb := newFakeBackends()
+		if err := fb.listen(nil); err != nil {
+			return err
+		}
+		defer fb.Close()
+		s = newServer()
+		fb.connect(s)
+		log.Println("Serving from in-memory dev backends; nothing is persisted")
+	} else if len(*replayPath) > 0 {
+		f, err := os.Open(*replayPath)
+		if err != nil {
+			return err
+		}
+		exchanges, err := readRecording(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error reading %v: %v", *replayPath, err)
+		}
+		rb := newReplayBackends(exchanges)
+		rb.realtime = *replayRealtime
+		if err := rb.listen(nil); err != nil {
+			return err
+		}
+		defer rb.Close()
+		s = newServer()
+		rb.connect(s)
+		log.Printf("Replaying %v backend exchanges from %v", len(exchanges), *replayPath)
+	} else {
+		var err error
+		if s, err = env.connect(*certPath, *keyPath); err != nil {
+			return err
+		}
+	}
+	if len(*recordPath) > 0 {
+		f, err := os.OpenFile(*recordPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		s.recorder = newRecorder(f)
+		log.Println("Recording backend exchanges to ", *recordPath)
+	}
+	s.debugToken = *debugToken
+	s.idempotencyTTL = *idempotencyTTL
+	s.urlPolicy.rejectPrivate = !*allowPrivateURLs
+	s.maxBodySize = *maxBodySize
+	if *maxInFlight > 0 {
+		s.inFlight = make(chan struct{}, *maxInFlight)
+	} else {
+		s.inFlight = nil
+	}
+	if *maxWatches > 0 {
+		s.watching = make(chan struct{}, *maxWatches)
+	} else {
+		s.watching = nil
+	}
+	s.readHeaderTimeout = *readHeaderTimeout
+	s.readTimeout = *readTimeout
+	s.writeTimeout = *writeTimeout
+	s.idleTimeout = *idleTimeout
+	if *compressionMinSize < 0 {
+		s.compression = nil
+	} else {
+		s.compression = newCompressionPolicy(*compressionMinSize, splitList(*compressionTypes))
+	}
+	if origins := splitList(*corsOrigins); len(origins) > 0 {
+		s.cors = &corsPolicy{
+			origins:     origins,
+			methods:     splitList(*corsMethods),
+			headers:     splitList(*corsHeaders),
+			credentials: *corsCredentials,
+			maxAge:      *corsMaxAge,
+		}
+		if err := s.cors.validate(); err != nil {
+			return err
+		}
+	}
+	switch {
+	case len(*apiKeysPath) > 0:
+		f, err := os.Open(*apiKeysPath)
+		if err != nil {
+			return err
+		}
+		keys, err := readAPIKeys(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error reading %v: %v", *apiKeysPath, err)
+		}
+		s.authenticate = keys.authenticate
+		if len(*membersPath) > 0 {
+			if s.members, err = readMemberStore(*membersPath); err != nil {
+				return fmt.Errorf("error reading %v: %v", *membersPath, err)
+			}
+		}
+	case *allowAnonymous || *devBackends || len(*replayPath) > 0:
+		log.Println("Serving without authentication")
+	default:
+		return errors.New("-api-keys is required; pass -allow-anonymous to serve without authentication")
+	}
 
 	defer func() {
 		if err := s.Close(); err != nil {
@@ -60,20 +220,35 @@ func main() {
 	exitChan := make(chan os.Signal)
 	signal.Notify(exitChan, syscall.SIGINT, syscall.SIGTERM)
 
-	go s.run(":80", errChan)
+	go s.run(*addr, errChan)
 
 	select {
-	case err = <-errChan:
-		log.Println("Error starting listener: ", err)
-		return
+	case err := <-errChan:
+		return fmt.Errorf("error starting listener: %v", err)
 	case exit := <-exitChan:
 		log.Println("Exiting: ", exit)
-		return
+		return nil
 	}
 }
 
 func newServer() *server {
-	s := &server{}
+	s := &server{
+		routeLimits:       defaultRouteLimits,
+		ipLimits:          defaultIPLimits,
+		watchInterval:     defaultWatchInterval,
+		watches:           newWatchHub(),
+		idempotencyStore:  newMemoryIdempotencyStore(),
+		members:           newMemberStore(),
+		idempotencyTTL:    defaultIdempotencyTTL,
+		compression:       newCompressionPolicy(defaultCompressionMinSize, defaultCompressionTypes),
+		bodyLimits:        defaultBodyLimits,
+		maxBodySize:       defaultMaxBodySize,
+		inFlight:          make(chan struct{}, defaultMaxInFlight),
+		watching:          make(chan struct{}, defaultMaxWatches),
+		readHeaderTimeout: defaultReadHeaderTimeout,
+		readTimeout:       defaultReadTimeout,
+		idleTimeout:       defaultIdleTimeout,
+	}
 	s.servicePool = sync.Pool{
 		New: func() interface{} {
 			return newService(s)
@@ -85,6 +260,27 @@ func newServer() *server {
 type server struct {
 	getOrgSvcConn      func() (net.Conn, error)
 	getEndpointSvcConn func() (net.Conn, error)
+	authenticate       func(*http.Request) (*caller, error)
+	members            *memberStore
+	routeLimits        map[string][]rateLimits
+	ipLimits           rateLimits
+	watchInterval      time.Duration
+	watches            *watchHub
+	idempotencyStore   idempotencyStore
+	idempotencyTTL     time.Duration
+	urlPolicy          urlPolicy
+	cors               *corsPolicy
+	compression        *compressionPolicy
+	bodyLimits         map[string]int64
+	maxBodySize        int64
+	inFlight           chan struct{}
+	watching           chan struct{}
+	readHeaderTimeout  time.Duration
+	readTimeout        time.Duration
+	writeTimeout       time.Duration
+	idleTimeout        time.Duration
+	recorder           *recorder
+	debugToken         string
 	servicePool        sync.Pool
 }
 
@@ -97,19 +293,38 @@ func (s *server) putService(svc *service) {
 	s.servicePool.Put(svc)
 }
 
-func (s *server) handler() http.Handler {
+func (s *server) router() *mux.Router {
 	r := mux.NewRouter()
-	r.HandleFunc("/organizations", s.organizationsHandler)
-	r.HandleFunc("/organizations/{organizationName}/endpoints", s.endpointsHandler)
-	r.HandleFunc("/organizations/{organizationName}/endpoints/{endpointID}", s.endpointsHandler)
-	r.HandleFunc("/health_check", s.healthCheckHandler)
+	doc := openAPI()
+	s.routeAPIVersions(r, doc)
+	r.HandleFunc("/health_check", httpMethods{http.MethodGet}.route(s.healthCheckHandler))
+	r.HandleFunc("/openapi.json", httpMethods{http.MethodGet}.route(s.openAPIHandler(doc)))
+	if len(s.debugToken) > 0 {
+		r.HandleFunc("/debug/backend", httpMethods{http.MethodGet, http.MethodPost}.route(s.debugBackendHandler))
+	}
 	return r
 }
 
+// handler wraps the router in the middleware that applies to every route.
+func (s *server) handler() http.Handler {
+	var h http.Handler = s.router()
+	if s.compression != nil {
+		h = s.compression.handler(h)
+	}
+	if s.cors != nil {
+		h = s.cors.handler(h)
+	}
+	return h
+}
+
 func (s *server) run(addr string, errChan chan error) {
 	httpServer := &http.Server{
-		Addr:    addr,
-		Handler: s.handler(),
+		Addr:              addr,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: s.readHeaderTimeout,
+		ReadTimeout:       s.readTimeout,
+		WriteTimeout:      s.writeTimeout,
+		IdleTimeout:       s.idleTimeout,
 	}
 
 	log.Printf("Listening for requests on %s...\n", addr)
@@ -121,109 +336,328 @@ func (s *server) Close() error {
 }
 
 func (s *server) endpointsHandler(w http.ResponseWriter, r *http.Request) {
-	ok := httpMethods{
-		http.MethodGet:  {},
-		http.MethodPost: {},
-	}.permit(r.Method, w)
-	if !ok {
-		return
-	}
-
-	vars := mux.Vars(r)
 	svc := s.getService()
 	defer s.putService(svc)
-	thisEndpoint := &endpoint{}
+	thisEndpoint := &endpoint{caller: callerID(r)}
 	if r.Method == http.MethodPost {
+		if !acceptable(w, r) {
+			return
+		}
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
-		thisEndpoint.URL = r.Form.Get("url")
+		normalized, ok := s.normalizeEndpointURL(w, r, r.Form.Get("url"))
+		if !ok {
+			return
+		}
+		thisEndpoint.URL = normalized
 		thisEndpoint.Action = endpoints.ActionNew
 	} else if r.Method == http.MethodGet {
-		thisEndpoint.ID = vars["endpointID"]
-		thisEndpoint.Action = endpoints.ActionRead
+		p, err := parsePage(r.URL.Query(), "url", "-url")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		thisEndpoint.page = p
+		thisEndpoint.Action = endpoints.ActionIndex
 	}
 
-	org := &organization{
-		Name:   vars["organizationName"],
-		action: organizations.ActionRead,
+	org := s.findOrganization(w, r, svc)
+	if org == nil {
+		return
 	}
-	orgs, err := svc.sync(org)
+	r = withOrganization(r, org)
+	thisEndpoint.OrganizationID = org.Name
+
+	if thisEndpoint.Action == endpoints.ActionIndex {
+		s.streamIndex(w, r, svc, thisEndpoint, thisEndpoint.page.limit)
+		return
+	}
+	endpointResponses, err := svc.sync(thisEndpoint)
 	if err != nil {
-		log.Printf("org request error %v", err)
+		log.Printf("endpoint request error %v", err)
 		http.Error(w, "internal application error", http.StatusInternalServerError)
 		return
 	}
-	orgResp := orgs[0].(*organization)
-	if thisEndpoint.OrganizationID = orgResp.Name; len(thisEndpoint.OrganizationID) == 0 {
-		// TODO 404?
-		log.Println("no organization ID returned")
+	endpointResponse := endpointResponses[0].(*endpoint)
+	if endpointResponse.err != nil {
+		writeResource(w, r, svc.builder, http.StatusBadRequest, endpointResponse)
+		return
+	}
+	w.Header().Set("ETag", etag(svc.builder, endpointResponse))
+	writeResource(w, r, svc.builder, http.StatusCreated, endpointResponse)
+}
+
+func (s *server) endpointHandler(w http.ResponseWriter, r *http.Request) {
+	svc := s.getService()
+	defer s.putService(svc)
+	if r.Method != http.MethodDelete && !acceptable(w, r) {
+		return
+	}
+	org := s.findOrganization(w, r, svc)
+	if org == nil {
+		return
+	}
+	r = withOrganization(r, org)
+
+	endpointResponses, err := svc.sync(&endpoint{
+		ID:             mux.Vars(r)["endpointID"],
+		OrganizationID: org.Name,
+		Action:         endpoints.ActionRead,
+		caller:         callerID(r),
+	})
+	if err != nil {
+		log.Printf("endpoint request error %v", err)
 		http.Error(w, "internal application error", http.StatusInternalServerError)
 		return
 	}
+	current := endpointResponses[0].(*endpoint)
+	if current.err != nil {
+		writeResource(w, r, svc.builder, http.StatusNotFound, current)
+		return
+	}
+	tag := etag(svc.builder, current)
+	if r.Method == http.MethodGet {
+		w.Header().Set("ETag", tag)
+		if ifNoneMatch(r, tag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeResource(w, r, svc.builder, http.StatusOK, current)
+		return
+	}
+	if !ifMatch(w, r, tag) {
+		return
+	}
 
-	endpointResponses, err := svc.sync(thisEndpoint)
+	// The update carries the version the tag was computed from, so the
+	// backend refuses it if the entity has changed since.
+	update := *current
+	update.caller = callerID(r)
+	if r.Method == http.MethodDelete {
+		update.Action = endpoints.ActionDelete
+	} else {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		// PUT replaces every field, PATCH only those in the request.
+		update.Action = endpoints.ActionUpdate
+		if _, ok := r.Form["url"]; ok || r.Method == http.MethodPut {
+			if update.URL, ok = s.normalizeEndpointURL(w, r, r.Form.Get("url")); !ok {
+				return
+			}
+		}
+		if _, ok := r.Form["schema"]; ok || r.Method == http.MethodPut {
+			update.Schema = r.Form.Get("schema")
+		}
+	}
+	endpointResponses, err = svc.sync(&update)
 	if err != nil {
-		log.Printf("org request error %v", err)
+		log.Printf("endpoint request error %v", err)
 		http.Error(w, "internal application error", http.StatusInternalServerError)
 		return
 	}
-	endpointResponse := endpointResponses[0].(*endpoint)
-	if endpointResponse.err != nil {
-		w.WriteHeader(http.StatusNotFound)
-	} else if r.Method == http.MethodPost {
-		w.WriteHeader(http.StatusCreated)
+	updated := endpointResponses[0].(*endpoint)
+	if updated.err != nil {
+		if updated.err.Error() == versionConflictErrMsg {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		writeResource(w, r, svc.builder, http.StatusBadRequest, updated)
+		return
 	}
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("ETag", etag(svc.builder, updated))
+	writeResource(w, r, svc.builder, http.StatusOK, updated)
+}
 
-	w.Header().Set(contentTypeHeader, jsonContentTypeValue)
-	json.NewEncoder(w).Encode(endpointResponse)
+// normalizeEndpointURL applies the server's URL policy to an endpoint URL
+// from a request. If the URL is rejected, it writes an error response and
+// returns false.
+func (s *server) normalizeEndpointURL(w http.ResponseWriter, r *http.Request, raw string) (string, bool) {
+	normalized, err := s.urlPolicy.normalize(r.Context(), raw)
+	if err != nil {
+		writeFieldErrors(w, &fieldError{In: "body", Field: "url", Message: err.Error()})
+		return "", false
+	}
+	return normalized, true
 }
 
-func (s *server) organizationsHandler(w http.ResponseWriter, r *http.Request) {
-	ok := httpMethods{
-		http.MethodGet:  {},
-		http.MethodPost: {},
-	}.permit(r.Method, w)
-	if !ok {
-		return
+// findOrganization reads the organization named in the request path. If it
+// can't, it writes an error response and returns nil.
+func (s *server) findOrganization(w http.ResponseWriter, r *http.Request, svc *service) *organization {
+	org, err := lookupOrganization(svc, mux.Vars(r)["organizationName"], callerID(r))
+	if err == errOrganizationNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	} else if err != nil {
+		log.Printf("org request error %v", err)
+		http.Error(w, "internal application error", http.StatusInternalServerError)
+		return nil
 	}
+	return org
+}
+
+var errOrganizationNotFound = errors.New("organization not found")
 
+// readOrganization reads the organization named name from the backend on
+// behalf of caller.
+func readOrganization(svc *service, name, caller string) (*organization, error) {
+	orgs, err := svc.sync(&organization{
+		Name:   name,
+		action: organizations.ActionRead,
+		caller: caller,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(orgs) == 0 || orgs[0].getErr() != nil || len(orgs[0].(*organization).Name) == 0 {
+		return nil, errOrganizationNotFound
+	}
+	return orgs[0].(*organization), nil
+}
+
+// lookupOrganization reads the organization addressed as name on behalf of
+// caller: the one with name's slug, or, since organizations created before
+// names were case-insensitive may have uppercase letters, the one named
+// exactly name.
+func lookupOrganization(svc *service, name, caller string) (*organization, error) {
+	slug := strings.ToLower(name)
+	org, err := readOrganization(svc, slug, caller)
+	if err == errOrganizationNotFound && name != slug {
+		return readOrganization(svc, name, caller)
+	}
+	return org, err
+}
+
+// setOrganizationName sets the slug and, if given, the display name of org
+// from the form in r. If the name breaks the slug rules, it writes an error
+// response and returns false.
+func setOrganizationName(w http.ResponseWriter, r *http.Request, org *organization) bool {
+	slug, err := organizationSlug(r.Form.Get("name"))
+	if err != nil {
+		writeFieldErrors(w, &fieldError{In: "body", Field: "name", Message: err.Error()})
+		return false
+	}
+	org.Name = slug
+	if _, ok := r.Form["displayName"]; ok {
+		org.DisplayName = strings.TrimSpace(r.Form.Get("displayName"))
+	}
+	return true
+}
+
+func (s *server) organizationsHandler(w http.ResponseWriter, r *http.Request) {
 	svc := s.getService()
 	defer s.putService(svc)
-	org := &organization{}
+	org := &organization{caller: callerID(r)}
 
 	if r.Method == http.MethodGet {
+		p, err := parsePage(r.URL.Query(), "name", "-name")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		org.page = p
 		org.action = organizations.ActionIndex
-	} else if r.Method == http.MethodPost {
+		s.streamIndex(w, r, svc, org, p.limit)
+		return
+	}
+
+	if !acceptable(w, r) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if !setOrganizationName(w, r, org) {
+		return
+	}
+	if len(org.DisplayName) == 0 {
+		org.DisplayName = r.Form.Get("name")
+	}
+
+	orgs, err := svc.sync(org)
+	if err != nil {
+		log.Printf("org request error %v", err)
+		http.Error(w, "internal application error", http.StatusInternalServerError)
+		return
+	}
+	created := orgs[0].(*organization)
+	if created.err != nil {
+		writeResource(w, r, svc.builder, http.StatusBadRequest, created)
+		return
+	}
+	w.Header().Set("ETag", etag(svc.builder, created))
+	writeResource(w, r, svc.builder, http.StatusCreated, created)
+}
+
+func (s *server) organizationHandler(w http.ResponseWriter, r *http.Request) {
+	svc := s.getService()
+	defer s.putService(svc)
+	if r.Method != http.MethodDelete && !acceptable(w, r) {
+		return
+	}
+	current := s.findOrganization(w, r, svc)
+	if current == nil {
+		return
+	}
+	tag := etag(svc.builder, current)
+	if r.Method == http.MethodGet {
+		w.Header().Set("ETag", tag)
+		if ifNoneMatch(r, tag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeResource(w, r, svc.builder, http.StatusOK, current)
+		return
+	}
+	if !ifMatch(w, r, tag) {
+		return
+	}
+
+	// The update carries the version the tag was computed from, so the
+	// backend refuses it if the entity has changed since.
+	update := *current
+	update.caller = callerID(r)
+	if r.Method == http.MethodDelete {
+		update.action = organizations.ActionDelete
+	} else {
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
-		org.Name = r.Form.Get("name")
+		update.action = organizations.ActionUpdate
+		if !setOrganizationName(w, r, &update) {
+			return
+		}
 	}
-
-	orgs, err := svc.sync(org)
+	orgs, err := svc.sync(&update)
 	if err != nil {
 		log.Printf("org request error %v", err)
 		http.Error(w, "internal application error", http.StatusInternalServerError)
 		return
 	}
-	valid := true
-	for _, orgResp := range orgs {
-		orgRes := orgResp.(*organization)
-		if orgRes.err != nil {
-			valid = false
-			break
+	updated := orgs[0].(*organization)
+	if updated.err != nil {
+		if updated.err.Error() == versionConflictErrMsg {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
 		}
+		writeResource(w, r, svc.builder, http.StatusBadRequest, updated)
+		return
 	}
-	if !valid {
-		w.WriteHeader(http.StatusBadRequest)
-	} else if r.Method == http.MethodPost {
-		w.WriteHeader(http.StatusCreated)
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
 	}
-	w.Header().Set(contentTypeHeader, jsonContentTypeValue)
-	json.NewEncoder(w).Encode(orgs)
+	w.Header().Set("ETag", etag(svc.builder, updated))
+	writeResource(w, r, svc.builder, http.StatusOK, updated)
 }
 
 func (s *server) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// memberStore holds the roles admins grant callers in their organizations
+// through the API, on top of those the callers' API keys configure. If path
+// is set, the roles are saved to it on every change, so they outlive the
+// process.
+type memberStore struct {
+	mu    sync.RWMutex
+	roles map[string]map[string]role // keyed by organization slug, then caller ID
+	path  string
+}
+
+func newMemberStore() *memberStore {
+	return &memberStore{roles: make(map[string]map[string]role)}
+}
+
+// readMemberStore returns a store saved to path, starting with the roles
+// already saved there, if any. The file is a JSON object mapping each
+// organization slug to the roles of its members, like
+//
+//	{"acme": {"ci": "editor"}}
+func readMemberStore(path string) (*memberStore, error) {
+	m := newMemberStore()
+	m.path = path
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var saved map[string]map[string]string
+	if err := json.NewDecoder(f).Decode(&saved); err != nil {
+		return nil, err
+	}
+	for org, members := range saved {
+		m.roles[org] = make(map[string]role, len(members))
+		for id, name := range members {
+			if m.roles[org][id], err = parseRole(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m, nil
+}
+
+// role returns the role granted to the caller with ID id in the organization
+// with slug org.
+func (m *memberStore) role(org, id string) role {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.roles[org][id]
+}
+
+// members returns the members of the organization with slug org, ordered by
+// ID.
+func (m *memberStore) members(org string) []*member {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	members := make([]*member, 0, len(m.roles[org]))
+	for id, r := range m.roles[org] {
+		members = append(members, &member{ID: id, Role: r.String()})
+	}
+	sort.Sort(membersByID(members))
+	return members
+}
+
+// set grants the caller with ID id role r in the organization with slug org,
+// returning whether they weren't a member before.
+func (m *memberStore) set(org, id string, r role) (created bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.roles[org] == nil {
+		m.roles[org] = make(map[string]role)
+	}
+	previous, ok := m.roles[org][id]
+	m.roles[org][id] = r
+	if err := m.save(); err != nil {
+		if ok {
+			m.roles[org][id] = previous
+		} else {
+			delete(m.roles[org], id)
+		}
+		return false, err
+	}
+	return !ok, nil
+}
+
+// remove revokes the role of the caller with ID id in the organization with
+// slug org, returning whether they were a member.
+func (m *memberStore) remove(org, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous, ok := m.roles[org][id]
+	if !ok {
+		return false, nil
+	}
+	delete(m.roles[org], id)
+	if err := m.save(); err != nil {
+		m.roles[org][id] = previous
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes the roles to the store's file, if it has one, replacing it
+// only once they're written in full. The caller must hold the lock.
+func (m *memberStore) save() error {
+	if len(m.path) == 0 {
+		return nil
+	}
+	saved := make(map[string]map[string]string, len(m.roles))
+	for org, members := range m.roles {
+		if len(members) == 0 {
+			continue
+		}
+		saved[org] = make(map[string]string, len(members))
+		for id, r := range members {
+			saved[org][id] = r.String()
+		}
+	}
+	tmp := m.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(saved)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// member is a caller's role in an organization, as represented to clients.
+type member struct {
+	ID   string `json:"id" msgpack:"id"`
+	Role string `json:"role" msgpack:"role"`
+	Self string `json:"self" msgpack:"self"`
+}
+
+type membersByID []*member
+
+func (m membersByID) Len() int           { return len(m) }
+func (m membersByID) Less(i, j int) bool { return m[i].ID < m[j].ID }
+func (m membersByID) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// setSelf sets the canonical URL of m within the organization of r.
+func (m *member) setSelf(r *http.Request) {
+	m.Self = (&url.URL{Path: requestAPIVersion(r).prefix + "/organizations/" + requestOrganizationName(r) + "/members/" + m.ID}).String()
+}
+
+// membersHandler lists the members of an organization.
+func (s *server) membersHandler(w http.ResponseWriter, r *http.Request) {
+	svc := s.getService()
+	defer s.putService(svc)
+	org := s.findOrganization(w, r, svc)
+	if org == nil {
+		return
+	}
+	r = withOrganization(r, org)
+
+	members := s.members.members(requestOrganizationSlug(r))
+	for _, m := range members {
+		m.setSelf(r)
+	}
+	var v interface{} = members
+	if requestAPIVersion(r).envelope {
+		v = map[string]interface{}{"data": members}
+	}
+	w.Header().Set(contentTypeHeader, jsonContentTypeValue)
+	json.NewEncoder(w).Encode(v)
+}
+
+// memberHandler reads, grants and revokes the role of one member of an
+// organization.
+func (s *server) memberHandler(w http.ResponseWriter, r *http.Request) {
+	svc := s.getService()
+	defer s.putService(svc)
+	org := s.findOrganization(w, r, svc)
+	if org == nil {
+		return
+	}
+	r = withOrganization(r, org)
+	slug, id := requestOrganizationSlug(r), mux.Vars(r)["memberID"]
+
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodDelete:
+		removed, err := s.members.remove(slug, id)
+		if err != nil {
+			log.Printf("member store error %v", err)
+			http.Error(w, "internal application error", http.StatusInternalServerError)
+			return
+		}
+		if !removed {
+			http.Error(w, notFoundErrMsg, http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPut:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		granted, err := parseRole(r.Form.Get("role"))
+		if err != nil || granted == roleNone {
+			writeFieldErrors(w, &fieldError{In: "body", Field: "role", Message: "must be one of viewer, editor, admin"})
+			return
+		}
+		created, err := s.members.set(slug, id, granted)
+		if err != nil {
+			log.Printf("member store error %v", err)
+			http.Error(w, "internal application error", http.StatusInternalServerError)
+			return
+		}
+		if created {
+			status = http.StatusCreated
+		}
+	}
+
+	granted := s.members.role(slug, id)
+	if granted == roleNone {
+		http.Error(w, notFoundErrMsg, http.StatusNotFound)
+		return
+	}
+	m := &member{ID: id, Role: granted.String()}
+	m.setSelf(r)
+	if status == http.StatusCreated {
+		w.Header().Set("Location", m.Self)
+	}
+	w.Header().Set(contentTypeHeader, jsonContentTypeValue)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(m)
+}
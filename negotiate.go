@@ -0,0 +1,53 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	jsonMediaType        = "application/json"
+	ndjsonMediaType      = "application/x-ndjson"
+	eventStreamMediaType = "text/event-stream"
+	msgpackMediaType     = "application/msgpack"
+	flatbuffersMediaType = "application/x-flatbuffers"
+)
+
+// negotiate returns the offer best matching the request's Accept header, or
+// an empty string if none is acceptable. Offers are in order of preference;
+// the first is chosen when the client accepts anything.
+func negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if len(strings.TrimSpace(accept)) == 0 {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		for _, spec := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(spec))
+			if err != nil || !mediaTypeMatches(mediaType, offer) {
+				continue
+			}
+			q := 1.0
+			if qParam, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(qParam, 64); err != nil {
+					continue
+				}
+			}
+			if q > bestQ {
+				best, bestQ = offer, q
+			}
+		}
+	}
+	return best
+}
+
+func mediaTypeMatches(pattern, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	return strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// The OpenAPI 3 document is maintained here rather than generated, and
+// TestOpenAPICoversRoutes checks it against the router.
+
+type openAPIDocument struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       openAPIInfo                `json:"info"`
+	Paths      map[string]openAPIPathItem `json:"paths"`
+	Components openAPIComponents          `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+// openAPIPathItem maps lowercase HTTP methods to operations.
+type openAPIPathItem map[string]*openAPIOperation
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+	Parameters  []*openAPIParameter        `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required,omitempty"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPISchema struct {
+	Ref         string                    `json:"$ref,omitempty"`
+	Type        string                    `json:"type,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Format      string                    `json:"format,omitempty"`
+	Pattern     string                    `json:"pattern,omitempty"`
+	Enum        []string                  `json:"enum,omitempty"`
+	MinLength   *int                      `json:"minLength,omitempty"`
+	MaxLength   *int                      `json:"maxLength,omitempty"`
+	Minimum     *float64                  `json:"minimum,omitempty"`
+	Maximum     *float64                  `json:"maximum,omitempty"`
+	Items       *openAPISchema            `json:"items,omitempty"`
+	Properties  map[string]*openAPISchema `json:"properties,omitempty"`
+	Required    []string                  `json:"required,omitempty"`
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+func schemaRef(name string) *openAPISchema {
+	return &openAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+var (
+	stringSchema           = &openAPISchema{Type: "string"}
+	organizationNameSchema = &openAPISchema{
+		Type:        "string",
+		Description: organizationNameRules,
+		MinLength:   intPtr(minOrganizationNameLength),
+		MaxLength:   intPtr(maxOrganizationNameLength),
+	}
+	displayNameSchema = &openAPISchema{Type: "string", MaxLength: intPtr(maxDisplayNameLength)}
+	endpointURLSchema = &openAPISchema{Type: "string", Format: "uri", MinLength: intPtr(1)}
+	roleSchema        = &openAPISchema{Type: "string", Enum: []string{"viewer", "editor", "admin"}}
+
+	organizationNameParam = &openAPIParameter{Name: "organizationName", In: "path", Required: true, Schema: stringSchema}
+	endpointIDParam       = &openAPIParameter{Name: "endpointID", In: "path", Required: true, Schema: stringSchema}
+	memberIDParam         = &openAPIParameter{Name: "memberID", In: "path", Required: true, Schema: stringSchema}
+	ifMatchParam          = &openAPIParameter{Name: "If-Match", In: "header", Required: true, Schema: stringSchema}
+	ifNoneMatchParam      = &openAPIParameter{Name: "If-None-Match", In: "header", Schema: stringSchema}
+	idempotencyKeyParam   = &openAPIParameter{Name: "Idempotency-Key", In: "header", Schema: stringSchema}
+)
+
+// indexParams are the query parameters of an index sorted by one of sorts.
+func indexParams(sorts ...string) []*openAPIParameter {
+	return []*openAPIParameter{
+		{Name: "limit", In: "query", Schema: &openAPISchema{Type: "integer", Minimum: float64Ptr(1), Maximum: float64Ptr(maxPageLimit)}},
+		{Name: "cursor", In: "query", Schema: stringSchema},
+		{Name: "sort", In: "query", Schema: &openAPISchema{Type: "string", Enum: sorts}},
+		{Name: "prefix", In: "query", Schema: stringSchema},
+	}
+}
+
+// formBody is a form-encoded request body with the given fields.
+func formBody(required []string, fields map[string]*openAPISchema) *openAPIRequestBody {
+	return &openAPIRequestBody{
+		Required: len(required) > 0,
+		Content: map[string]openAPIMediaType{
+			"application/x-www-form-urlencoded": {Schema: &openAPISchema{Type: "object", Properties: fields, Required: required}},
+		},
+	}
+}
+
+func jsonResponse(description string, schema *openAPISchema) openAPIResponse {
+	return openAPIResponse{
+		Description: description,
+		Content:     map[string]openAPIMediaType{jsonMediaType: {Schema: schema}},
+	}
+}
+
+func textResponse(description string) openAPIResponse {
+	return openAPIResponse{Description: description}
+}
+
+// openAPIPaths returns the operations of v's routes, keyed by path relative
+// to the version's prefix.
+func openAPIPaths(v *apiVersion) map[string]openAPIPathItem {
+	org, endpoint := schemaRef("Organization"), schemaRef("Endpoint")
+	if v == legacyAPI {
+		org, endpoint = schemaRef("LegacyOrganization"), schemaRef("LegacyEndpoint")
+	}
+	list := func(item *openAPISchema) *openAPISchema {
+		items := &openAPISchema{Type: "array", Items: item}
+		if v.envelope {
+			return &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{
+				"data":  items,
+				"next":  stringSchema,
+				"error": stringSchema,
+			}}
+		}
+		return items
+	}
+	orgFields := map[string]*openAPISchema{"name": organizationNameSchema, "displayName": displayNameSchema}
+	endpointFields := map[string]*openAPISchema{"url": endpointURLSchema, "schema": stringSchema}
+
+	return map[string]openAPIPathItem{
+		"/organizations": {
+			"get": {
+				Summary:    "List organizations",
+				Parameters: indexParams("name", "-name"),
+				Responses:  map[string]openAPIResponse{"200": jsonResponse("Organizations", list(org))},
+			},
+			"post": {
+				Summary:     "Create an organization",
+				Parameters:  []*openAPIParameter{idempotencyKeyParam},
+				RequestBody: formBody([]string{"name"}, orgFields),
+				Responses: map[string]openAPIResponse{
+					"201": jsonResponse("Created organization", org),
+					"400": jsonResponse("Rejected organization", org),
+				},
+			},
+		},
+		"/organizations/{organizationName}": {
+			"get": {
+				Summary:    "Read an organization",
+				Parameters: []*openAPIParameter{organizationNameParam, ifNoneMatchParam},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Organization", org),
+					"304": textResponse("Not modified"),
+					"404": textResponse("Organization not found"),
+				},
+			},
+			"put": {
+				Summary:     "Rename an organization",
+				Parameters:  []*openAPIParameter{organizationNameParam, ifMatchParam},
+				RequestBody: formBody([]string{"name"}, orgFields),
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Updated organization", org),
+					"412": textResponse("Organization has changed"),
+					"428": textResponse("If-Match required"),
+				},
+			},
+			"delete": {
+				Summary:    "Delete an organization",
+				Parameters: []*openAPIParameter{organizationNameParam, ifMatchParam},
+				Responses: map[string]openAPIResponse{
+					"204": textResponse("Deleted"),
+					"412": textResponse("Organization has changed"),
+					"428": textResponse("If-Match required"),
+				},
+			},
+		},
+		"/organizations/{organizationName}/endpoints": {
+			"get": {
+				Summary:    "List an organization's endpoints",
+				Parameters: append([]*openAPIParameter{organizationNameParam}, indexParams("url", "-url")...),
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Endpoints", list(endpoint)),
+					"404": textResponse("Organization not found"),
+				},
+			},
+			"post": {
+				Summary:     "Create an endpoint",
+				Parameters:  []*openAPIParameter{organizationNameParam, idempotencyKeyParam},
+				RequestBody: formBody([]string{"url"}, endpointFields),
+				Responses: map[string]openAPIResponse{
+					"201": jsonResponse("Created endpoint", endpoint),
+					"400": jsonResponse("Rejected endpoint", endpoint),
+					"404": textResponse("Organization not found"),
+				},
+			},
+		},
+		"/organizations/{organizationName}/endpoints/{endpointID}": {
+			"get": {
+				Summary:    "Read an endpoint",
+				Parameters: []*openAPIParameter{organizationNameParam, endpointIDParam, ifNoneMatchParam},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Endpoint", endpoint),
+					"304": textResponse("Not modified"),
+					"404": jsonResponse("Endpoint not found", endpoint),
+				},
+			},
+			"put": {
+				Summary:     "Replace an endpoint",
+				Parameters:  []*openAPIParameter{organizationNameParam, endpointIDParam, ifMatchParam},
+				RequestBody: formBody([]string{"url"}, endpointFields),
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Updated endpoint", endpoint),
+					"412": textResponse("Endpoint has changed"),
+					"428": textResponse("If-Match required"),
+				},
+			},
+			"patch": {
+				Summary:     "Update an endpoint",
+				Parameters:  []*openAPIParameter{organizationNameParam, endpointIDParam, ifMatchParam},
+				RequestBody: formBody(nil, endpointFields),
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Updated endpoint", endpoint),
+					"412": textResponse("Endpoint has changed"),
+					"428": textResponse("If-Match required"),
+				},
+			},
+			"delete": {
+				Summary:    "Delete an endpoint",
+				Parameters: []*openAPIParameter{organizationNameParam, endpointIDParam, ifMatchParam},
+				Responses: map[string]openAPIResponse{
+					"204": textResponse("Deleted"),
+					"412": textResponse("Endpoint has changed"),
+					"428": textResponse("If-Match required"),
+				},
+			},
+		},
+		"/organizations/{organizationName}/endpoints:batch": {
+			"post": {
+				Summary: "Create endpoints in a batch",
+				Parameters: []*openAPIParameter{
+					organizationNameParam,
+					idempotencyKeyParam,
+					{Name: "atomic", In: "query", Schema: &openAPISchema{Type: "boolean"}},
+				},
+				RequestBody: &openAPIRequestBody{
+					Required: true,
+					Content: map[string]openAPIMediaType{jsonMediaType: {Schema: &openAPISchema{
+						Type:  "array",
+						Items: &openAPISchema{Type: "object", Properties: endpointFields, Required: []string{"url"}},
+					}}},
+				},
+				Responses: map[string]openAPIResponse{
+					"207": jsonResponse("Result of each endpoint, in order", &openAPISchema{Type: "array", Items: schemaRef("BatchResult")}),
+					"404": textResponse("Organization not found"),
+					"415": textResponse("Batch is not JSON"),
+					"501": textResponse("Atomic batches are not supported yet"),
+				},
+			},
+		},
+		"/organizations/{organizationName}/export": {
+			"get": {
+				Summary:    "Export an organization and its endpoints",
+				Parameters: []*openAPIParameter{organizationNameParam},
+				Responses: map[string]openAPIResponse{
+					"200": {Description: "Archive", Content: map[string]openAPIMediaType{
+						jsonMediaType: {Schema: schemaRef("Archive")},
+						tarMediaType:  {Schema: &openAPISchema{Type: "string", Format: "binary"}},
+					}},
+					"404": textResponse("Organization not found"),
+					"406": textResponse("Not acceptable"),
+				},
+			},
+		},
+		"/organizations/{organizationName}/import": {
+			"post": {
+				Summary: "Import an organization and its endpoints",
+				Parameters: []*openAPIParameter{
+					organizationNameParam,
+					idempotencyKeyParam,
+					{Name: "conflict", In: "query", Schema: &openAPISchema{Type: "string", Enum: []string{conflictFail, conflictSkip, conflictOverwrite}}},
+					{Name: "dryRun", In: "query", Schema: &openAPISchema{Type: "boolean"}},
+				},
+				RequestBody: &openAPIRequestBody{
+					Required: true,
+					Content: map[string]openAPIMediaType{
+						jsonMediaType: {Schema: schemaRef("Archive")},
+						tarMediaType:  {Schema: &openAPISchema{Type: "string", Format: "binary"}},
+					},
+				},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Import report", schemaRef("ImportReport")),
+					"201": jsonResponse("Import report for a created organization", schemaRef("ImportReport")),
+					"207": jsonResponse("Import report with failures", schemaRef("ImportReport")),
+					"409": jsonResponse("Import report with conflicts", schemaRef("ImportReport")),
+					"415": textResponse("Unsupported archive format"),
+				},
+			},
+		},
+		"/organizations/{organizationName}/members": {
+			"get": {
+				Summary:    "List the roles granted in an organization",
+				Parameters: []*openAPIParameter{organizationNameParam},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Members", list(schemaRef("Member"))),
+					"404": textResponse("Organization not found"),
+				},
+			},
+		},
+		"/organizations/{organizationName}/members/{memberID}": {
+			"get": {
+				Summary:    "Read a member's role",
+				Parameters: []*openAPIParameter{organizationNameParam, memberIDParam},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Member", schemaRef("Member")),
+					"404": textResponse("Member not found"),
+				},
+			},
+			"put": {
+				Summary:     "Grant a caller a role",
+				Parameters:  []*openAPIParameter{organizationNameParam, memberIDParam},
+				RequestBody: formBody([]string{"role"}, map[string]*openAPISchema{"role": roleSchema}),
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Updated member", schemaRef("Member")),
+					"201": jsonResponse("Added member", schemaRef("Member")),
+					"404": textResponse("Organization not found"),
+				},
+			},
+			"delete": {
+				Summary:    "Revoke a member's role",
+				Parameters: []*openAPIParameter{organizationNameParam, memberIDParam},
+				Responses: map[string]openAPIResponse{
+					"204": textResponse("Revoked"),
+					"404": textResponse("Member not found"),
+				},
+			},
+		},
+	}
+}
+
+func objectSchema(fields ...string) *openAPISchema {
+	schema := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	for _, field := range fields {
+		schema.Properties[field] = stringSchema
+	}
+	return schema
+}
+
+// openAPI returns the OpenAPI document describing every route of the
+// frontend.
+func openAPI() *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: "knollit HTTP frontend", Version: apiVersions[len(apiVersions)-1].name},
+		Paths: map[string]openAPIPathItem{
+			"/health_check": {
+				"get": {
+					Summary: "Check the backend services are reachable",
+					Responses: map[string]openAPIResponse{
+						"204": textResponse("Healthy"),
+						"503": textResponse("A backend is unavailable"),
+					},
+				},
+			},
+			"/openapi.json": {
+				"get": {
+					Summary:   "This document",
+					Responses: map[string]openAPIResponse{"200": textResponse("OpenAPI document")},
+				},
+			},
+		},
+		Components: openAPIComponents{Schemas: map[string]*openAPISchema{
+			"Organization":       objectSchema("id", "name", "displayName", "self"),
+			"Endpoint":           objectSchema("id", "organizationId", "url", "schema", "self"),
+			"LegacyOrganization": objectSchema("id", "name", "displayName", "self"),
+			"LegacyEndpoint":     objectSchema("ID", "OrganizationID", "URL", "Schema", "self"),
+			"Member":             objectSchema("id", "role", "self"),
+			"BatchResult": {Type: "object", Properties: map[string]*openAPISchema{
+				"status":   {Type: "integer"},
+				"location": stringSchema,
+				"data":     {Type: "object"},
+				"error":    stringSchema,
+				"errors":   {Type: "array", Items: &openAPISchema{Type: "object"}},
+			}},
+			"Archive": {Type: "object", Required: []string{"version", "organization", "endpoints"}, Properties: map[string]*openAPISchema{
+				"version":      {Type: "integer"},
+				"organization": objectSchema("name", "displayName"),
+				"endpoints":    {Type: "array", Items: objectSchema("id", "url", "schema")},
+			}},
+			"ImportReport": {Type: "object", Properties: map[string]*openAPISchema{
+				"dryRun":       {Type: "boolean"},
+				"conflict":     stringSchema,
+				"organization": schemaRef("ImportResult"),
+				"endpoints":    {Type: "array", Items: schemaRef("ImportResult")},
+			}},
+			"ImportResult": {Type: "object", Properties: map[string]*openAPISchema{
+				"name":   stringSchema,
+				"url":    stringSchema,
+				"action": {Type: "string", Enum: []string{importCreate, importOverwrite, importSkip, importConflict}},
+				"status": {Type: "integer"},
+				"error":  stringSchema,
+			}},
+		}},
+	}
+	for _, v := range append([]*apiVersion{legacyAPI}, apiVersions...) {
+		for path, item := range openAPIPaths(v) {
+			if v == legacyAPI {
+				for _, op := range item {
+					op.Deprecated = true
+				}
+			}
+			doc.Paths[v.prefix+path] = item
+		}
+	}
+	return doc
+}
+
+func (s *server) openAPIHandler(doc *openAPIDocument) http.HandlerFunc {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentTypeHeader, jsonContentTypeValue)
+		w.Write(data)
+	}
+}
@@ -3,16 +3,25 @@ package main
 import (
 	"errors"
 	"net"
+	"net/http"
+	"net/url"
 
 	"github.com/google/flatbuffers/go"
 	"github.com/knollit/http_frontend/organizations"
 )
 
 type organization struct {
-	ID     string `json:"id"`
-	Name   string `json:"name"`
-	action int8
-	err    error
+	ID          string `json:"id" msgpack:"id"`
+	Name        string `json:"name" msgpack:"name"`
+	DisplayName string `json:"displayName" msgpack:"displayName"`
+	Self        string `json:"self" msgpack:"self"`
+	action      int8
+	caller      string
+	version     uint64
+	page        page
+	err         error
+	// frame is the backend frame the organization was decoded from, if any.
+	frame []byte
 }
 
 func (org *organization) new() serviceMsg {
@@ -23,13 +32,45 @@ func (org *organization) getConn(s *server) (net.Conn, error) {
 	return s.getOrgSvcConn()
 }
 
+func (org *organization) getID() string {
+	return org.ID
+}
+
+func (org *organization) getErr() error {
+	return org.err
+}
+
+func (org *organization) getFrame() []byte {
+	return org.frame
+}
+
+func (org *organization) setFrame(frame []byte) {
+	org.frame = frame
+}
+
+// idempotent reports whether the organization service can safely be sent the
+// request twice.
+func (org *organization) idempotent() bool {
+	return org.action == organizations.ActionRead || org.action == organizations.ActionIndex
+}
+
+// setSelf sets and returns the canonical URL of the organization.
+func (org *organization) setSelf(r *http.Request) string {
+	if len(org.Name) > 0 {
+		org.Self = (&url.URL{Path: requestAPIVersion(r).prefix + "/organizations/" + org.Name}).String()
+	}
+	return org.Self
+}
+
 func (org *organization) fromBytes(bytes []byte) {
 	org.fromFlatBufferMsg(organizations.GetRootAsOrganization(bytes, 0))
 }
 
 func (org *organization) fromFlatBufferMsg(msg *organizations.Organization) {
 	org.Name = string(msg.Name())
+	org.DisplayName = string(msg.DisplayName())
 	org.ID = string(msg.ID())
+	org.version = msg.Version()
 	if len(msg.Error()) > 0 {
 		org.err = errors.New(string(msg.Error()))
 	}
@@ -40,12 +81,35 @@ func (org *organization) toFlatBufferBytes(b *flatbuffers.Builder) []byte {
 
 	idPosition := b.CreateByteString([]byte(org.ID))
 	namePosition := b.CreateByteString([]byte(org.Name))
+	displayNamePosition := b.CreateByteString([]byte(org.DisplayName))
+	callerPosition := b.CreateByteString([]byte(org.caller))
+	cursorPosition := b.CreateByteString([]byte(org.page.after))
+	sortPosition := b.CreateByteString([]byte(org.page.sort))
+	prefixPosition := b.CreateByteString([]byte(org.page.prefix))
+	var errPosition flatbuffers.UOffsetT
+	if org.err != nil {
+		errPosition = b.CreateByteString([]byte(org.err.Error()))
+	}
 
 	organizations.OrganizationStart(b)
 
 	organizations.OrganizationAddID(b, idPosition)
 	organizations.OrganizationAddName(b, namePosition)
+	organizations.OrganizationAddDisplayName(b, displayNamePosition)
 	organizations.OrganizationAddAction(b, org.action)
+	organizations.OrganizationAddCaller(b, callerPosition)
+	if org.page.limit > 0 {
+		organizations.OrganizationAddLimit(b, int32(org.page.limit+1))
+	}
+	organizations.OrganizationAddCursor(b, cursorPosition)
+	organizations.OrganizationAddSort(b, sortPosition)
+	organizations.OrganizationAddPrefix(b, prefixPosition)
+	if org.version > 0 {
+		organizations.OrganizationAddVersion(b, org.version)
+	}
+	if org.err != nil {
+		organizations.OrganizationAddError(b, errPosition)
+	}
 
 	orgPosition := organizations.OrganizationEnd(b)
 	b.Finish(orgPosition)
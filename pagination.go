@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// page holds the pagination, sorting and filtering parameters of an index
+// request.
+type page struct {
+	limit  int
+	after  string // ID of the last item on the previous page
+	sort   string
+	prefix string
+}
+
+// parsePage reads limit, cursor, sort and prefix from q. The first of sorts
+// is the default order.
+func parsePage(q url.Values, sorts ...string) (p page, err error) {
+	p.limit = defaultPageLimit
+	if limit := q.Get("limit"); len(limit) > 0 {
+		if p.limit, err = strconv.Atoi(limit); err != nil || p.limit < 1 || p.limit > maxPageLimit {
+			return p, fmt.Errorf("limit must be between 1 and %v", maxPageLimit)
+		}
+	}
+	if cursor := q.Get("cursor"); len(cursor) > 0 {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return p, errors.New("invalid cursor")
+		}
+		p.after = string(after)
+	}
+	p.sort = sorts[0]
+	if sort := q.Get("sort"); len(sort) > 0 {
+		valid := false
+		for _, s := range sorts {
+			valid = valid || s == sort
+		}
+		if !valid {
+			return p, fmt.Errorf("sort must be one of %v", sorts)
+		}
+		p.sort = sort
+	}
+	p.prefix = q.Get("prefix")
+	return
+}
+
+// nextLink sets a Link header, or trailer if the body is under way, pointing
+// at the page after the item with ID lastID, and returns that page's cursor.
+func nextLink(w http.ResponseWriter, r *http.Request, lastID string) string {
+	cursor := base64.RawURLEncoding.EncodeToString([]byte(lastID))
+	q := r.URL.Query()
+	q.Set("cursor", cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	w.Header().Add("Link", fmt.Sprintf(`<%v>; rel="next"`, next.String()))
+	return cursor
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBuckets bounds how many token buckets a limiter keeps. Once it's
+	// reached, the least recently used bucket makes way for a new client's.
+	maxBuckets = 10000
+	// pruneInterval is how often buckets idle for longer are dropped.
+	pruneInterval = time.Minute
+)
+
+// rateLimit is a token bucket budget: up to burst requests at once, refilled
+// at rate requests per second. A zero rate means unlimited.
+type rateLimit struct {
+	rate  float64
+	burst int
+}
+
+// rateLimits configures one limiter on a route: which requests share a
+// bucket, and separate budgets for reads and writes.
+type rateLimits struct {
+	key   func(*http.Request) string
+	read  rateLimit
+	write rateLimit
+}
+
+// defaultRouteLimits are keyed by route path template. Endpoint routes are
+// also limited per organization, since each of their requests fans out to
+// the organization service.
+var defaultRouteLimits = map[string][]rateLimits{
+	"/organizations": {
+		{key: clientKey, read: rateLimit{rate: 10, burst: 20}, write: rateLimit{rate: 1, burst: 5}},
+	},
+	"/organizations/{organizationName}": {
+		{key: clientKey, read: rateLimit{rate: 20, burst: 40}, write: rateLimit{rate: 1, burst: 5}},
+	},
+	"/organizations/{organizationName}/endpoints": {
+		{key: clientKey, read: rateLimit{rate: 20, burst: 40}, write: rateLimit{rate: 5, burst: 10}},
+		{key: organizationKey, read: rateLimit{rate: 50, burst: 100}, write: rateLimit{rate: 10, burst: 20}},
+	},
+	"/organizations/{organizationName}/endpoints/{endpointID}": {
+		{key: clientKey, read: rateLimit{rate: 20, burst: 40}, write: rateLimit{rate: 5, burst: 10}},
+		{key: organizationKey, read: rateLimit{rate: 50, burst: 100}, write: rateLimit{rate: 10, burst: 20}},
+	},
+	"/organizations/{organizationName}/endpoints:batch": {
+		{key: clientKey, write: rateLimit{rate: 0.2, burst: 2}},
+		{key: organizationKey, write: rateLimit{rate: 0.5, burst: 4}},
+	},
+	"/organizations/{organizationName}/export": {
+		{key: clientKey, read: rateLimit{rate: 0.2, burst: 2}},
+	},
+	"/organizations/{organizationName}/import": {
+		{key: clientKey, write: rateLimit{rate: 0.1, burst: 2}},
+	},
+	"/organizations/{organizationName}/members": {
+		{key: clientKey, read: rateLimit{rate: 5, burst: 10}},
+	},
+	"/organizations/{organizationName}/members/{memberID}": {
+		{key: clientKey, read: rateLimit{rate: 5, burst: 10}, write: rateLimit{rate: 1, burst: 5}},
+	},
+}
+
+// defaultIPLimits apply to every API request, by client IP, before the
+// caller is authenticated, so requests refused with 401 or 403 are limited
+// too. They're generous enough for many callers behind one address.
+var defaultIPLimits = rateLimits{key: ipKey, read: rateLimit{rate: 100, burst: 200}, write: rateLimit{rate: 20, burst: 40}}
+
+// clientKey identifies the client by authenticated caller ID, or by IP if the
+// request is anonymous.
+func clientKey(r *http.Request) string {
+	if id := callerID(r); len(id) > 0 {
+		return "caller:" + id
+	}
+	return ipKey(r)
+}
+
+func ipKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func organizationKey(r *http.Request) string {
+	return "org:" + requestOrganizationSlug(r)
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	limits rateLimits
+	now    func() time.Time
+	mu     sync.Mutex
+	// buckets indexes the elements of lru, which holds the buckets from the
+	// most to the least recently used.
+	buckets map[string]*list.Element
+	lru     *list.List
+	pruned  time.Time
+}
+
+func newRateLimiter(limits rateLimits) *rateLimiter {
+	return &rateLimiter{
+		limits:  limits,
+		now:     time.Now,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// take removes a token from the bucket for key, returning whether one was
+// available, the tokens left and how long until the next one.
+func (l *rateLimiter) take(key string, limit rateLimit) (ok bool, remaining float64, wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.pruned) >= pruneInterval {
+		l.prune(now)
+	}
+	var bucket *tokenBucket
+	if elem, found := l.buckets[key]; found {
+		l.lru.MoveToFront(elem)
+		bucket = elem.Value.(*tokenBucket)
+	} else {
+		if l.lru.Len() >= maxBuckets {
+			l.remove(l.lru.Back())
+		}
+		bucket = &tokenBucket{key: key, tokens: float64(limit.burst), last: now}
+		l.buckets[key] = l.lru.PushFront(bucket)
+	}
+	bucket.tokens = math.Min(float64(limit.burst), bucket.tokens+now.Sub(bucket.last).Seconds()*limit.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, bucket.tokens, time.Duration((1 - bucket.tokens) / limit.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, bucket.tokens, 0
+}
+
+// prune drops the buckets idle for longer than pruneInterval. It's run at
+// most once per pruneInterval, and the idle buckets are the least recently
+// used, so the sweep stops at the first bucket still in use.
+func (l *rateLimiter) prune(now time.Time) {
+	for elem := l.lru.Back(); elem != nil && now.Sub(elem.Value.(*tokenBucket).last) > pruneInterval; elem = l.lru.Back() {
+		l.remove(elem)
+	}
+	l.pruned = now
+}
+
+func (l *rateLimiter) remove(elem *list.Element) {
+	delete(l.buckets, elem.Value.(*tokenBucket).key)
+	l.lru.Remove(elem)
+}
+
+func (l *rateLimiter) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, prefix := l.limits.write, "w:"
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			limit, prefix = l.limits.read, "r:"
+		}
+		if limit.rate == 0 {
+			next(w, r)
+			return
+		}
+
+		ok, remaining, wait := l.take(prefix+l.limits.key(r), limit)
+		reset := (float64(limit.burst) - remaining) / limit.rate
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(remaining)))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset))))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// routeLimiters holds the rate limiters of each route path template, so
+// handlers registered for the same path share them.
+type routeLimiters map[string][]*rateLimiter
+
+// limit wraps next in the rate limiters configured for path.
+func (limiters routeLimiters) limit(s *server, path string, next http.HandlerFunc) http.HandlerFunc {
+	if _, ok := limiters[path]; !ok {
+		limiters[path] = []*rateLimiter{}
+		for _, limits := range s.routeLimits[path] {
+			limiters[path] = append(limiters[path], newRateLimiter(limits))
+		}
+	}
+	for _, l := range limiters[path] {
+		next = l.limit(next)
+	}
+	return next
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/flatbuffers/go"
+	"github.com/mikeraimondi/prefixedio"
+)
+
+// Names of the backends in recordings.
+const (
+	organizationService = "organizations"
+	endpointService     = "endpoints"
+)
+
+// exchange is one connection to a backend as recorded: the request frame, and
+// each response frame with how long after the request it arrived. Recordings
+// are JSON lines of exchanges, with frames base64-encoded.
+type exchange struct {
+	Service   string          `json:"service"`
+	Time      time.Time       `json:"time"`
+	Request   []byte          `json:"request"`
+	Responses []recordedFrame `json:"responses"`
+	Duration  time.Duration   `json:"duration"`
+	Error     string          `json:"error,omitempty"`
+}
+
+type recordedFrame struct {
+	Frame []byte        `json:"frame"`
+	After time.Duration `json:"after"`
+}
+
+func serviceName(msg serviceMsg) string {
+	if _, ok := msg.(*organization); ok {
+		return organizationService
+	}
+	return endpointService
+}
+
+// recorder writes the exchanges of service.stream to a recording.
+type recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newRecorder(w io.Writer) *recorder {
+	return &recorder{enc: json.NewEncoder(w)}
+}
+
+// startExchange returns an exchange for req, begun now.
+func startExchange(req serviceMsg) *exchange {
+	return &exchange{Service: serviceName(req), Time: time.Now()}
+}
+
+// request records the request frame, which is copied since it belongs to the
+// service's builder.
+func (x *exchange) request(frame []byte) {
+	x.Request = append([]byte(nil), frame...)
+}
+
+// response records a response frame, which is copied since it belongs to the
+// service's buffer.
+func (x *exchange) response(frame []byte) {
+	x.Responses = append(x.Responses, recordedFrame{
+		Frame: append([]byte(nil), frame...),
+		After: time.Since(x.Time),
+	})
+}
+
+// end records how long x took, and err if it failed.
+func (x *exchange) end(err error) {
+	x.Duration = time.Since(x.Time)
+	if err != nil {
+		x.Error = err.Error()
+	}
+}
+
+// finish ends x with err and writes it.
+func (rec *recorder) finish(x *exchange, err error) error {
+	x.end(err)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.enc.Encode(x)
+}
+
+// readRecording reads the exchanges of a recording.
+func readRecording(r io.Reader) (exchanges []*exchange, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		x := new(exchange)
+		if err := json.Unmarshal(scanner.Bytes(), x); err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		if x.Service != organizationService && x.Service != endpointService {
+			return nil, fmt.Errorf("line %v: unknown service %q", line, x.Service)
+		}
+		exchanges = append(exchanges, x)
+	}
+	return exchanges, scanner.Err()
+}
+
+var errNotRecorded = errors.New("no recorded response for request")
+
+// replayBackends serve a recording as the organization and endpoint services.
+// A request is answered with the responses to the first unreplayed recorded
+// request with the same bytes, or the last one replayed if every one has
+// been. Requests that weren't recorded are answered with an error.
+type replayBackends struct {
+	localBackends
+	mu        sync.Mutex
+	exchanges []*exchange
+	replayed  []bool
+	// realtime delays responses as long as they took to arrive when recorded.
+	realtime bool
+}
+
+func newReplayBackends(exchanges []*exchange) *replayBackends {
+	return &replayBackends{exchanges: exchanges, replayed: make([]bool, len(exchanges))}
+}
+
+// listen serves both services, over TLS if tlsConf isn't nil.
+func (rb *replayBackends) listen(tlsConf *tls.Config) error {
+	return rb.localBackends.listen(tlsConf, rb.handle(organizationService), rb.handle(endpointService))
+}
+
+// find returns the exchange to replay for a request to service.
+func (rb *replayBackends) find(service string, frame []byte) *exchange {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	var last *exchange
+	for i, x := range rb.exchanges {
+		if x.Service != service || !bytes.Equal(x.Request, frame) {
+			continue
+		}
+		if !rb.replayed[i] {
+			rb.replayed[i] = true
+			return x
+		}
+		last = x
+	}
+	return last
+}
+
+func (rb *replayBackends) handle(service string) frameHandler {
+	return func(w io.Writer, frame []byte) error {
+		x := rb.find(service, frame)
+		if x == nil {
+			var msg serviceMsg = &endpoint{err: errNotRecorded}
+			if service == organizationService {
+				msg = &organization{err: errNotRecorded}
+			}
+			_, err := prefixedio.WriteBytes(w, msg.toFlatBufferBytes(flatbuffers.NewBuilder(0)))
+			return err
+		}
+		start := time.Now()
+		for _, resp := range x.Responses {
+			if rb.realtime {
+				time.Sleep(resp.After - time.Since(start))
+			}
+			if _, err := prefixedio.WriteBytes(w, resp.Frame); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import "net/http"
+
+// representLegacy encodes the internal structs directly, as the original
+// unversioned routes did.
+func representLegacy(msg serviceMsg) interface{} {
+	return msg
+}
+
+type organizationV1 struct {
+	ID          string `json:"id" msgpack:"id"`
+	Name        string `json:"name" msgpack:"name"`
+	DisplayName string `json:"displayName" msgpack:"displayName"`
+	Self        string `json:"self" msgpack:"self"`
+}
+
+type endpointV1 struct {
+	ID             string `json:"id" msgpack:"id"`
+	OrganizationID string `json:"organizationId" msgpack:"organizationId"`
+	URL            string `json:"url" msgpack:"url"`
+	Schema         string `json:"schema" msgpack:"schema"`
+	Self           string `json:"self" msgpack:"self"`
+}
+
+func representV1(msg serviceMsg) interface{} {
+	switch m := msg.(type) {
+	case *organization:
+		return organizationV1{ID: m.ID, Name: m.Name, DisplayName: m.DisplayName, Self: m.Self}
+	case *endpoint:
+		return endpointV1{ID: m.ID, OrganizationID: m.OrganizationID, URL: m.URL, Schema: m.Schema, Self: m.Self}
+	}
+	return msg
+}
+
+// organizationV2 and endpointV2 have v1's fields so far. They're separate
+// types so v2 can change without changing v1.
+type organizationV2 struct {
+	ID          string `json:"id" msgpack:"id"`
+	Name        string `json:"name" msgpack:"name"`
+	DisplayName string `json:"displayName" msgpack:"displayName"`
+	Self        string `json:"self" msgpack:"self"`
+}
+
+type endpointV2 struct {
+	ID             string `json:"id" msgpack:"id"`
+	OrganizationID string `json:"organizationId" msgpack:"organizationId"`
+	URL            string `json:"url" msgpack:"url"`
+	Schema         string `json:"schema" msgpack:"schema"`
+	Self           string `json:"self" msgpack:"self"`
+}
+
+func representV2(msg serviceMsg) interface{} {
+	switch m := msg.(type) {
+	case *organization:
+		return organizationV2{ID: m.ID, Name: m.Name, DisplayName: m.DisplayName, Self: m.Self}
+	case *endpoint:
+		return endpointV2{ID: m.ID, OrganizationID: m.OrganizationID, URL: m.URL, Schema: m.Schema, Self: m.Self}
+	}
+	return msg
+}
+
+// represent returns msg as the API version of r represents it to clients.
+func represent(r *http.Request, msg serviceMsg) interface{} {
+	msg.setSelf(r)
+	return requestAPIVersion(r).represent(msg)
+}
@@ -1,18 +1,35 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"io"
+	"log"
 	"net"
+	"net/http"
+	"time"
 
 	"github.com/google/flatbuffers/go"
 	"github.com/mikeraimondi/prefixedio"
 )
 
+// errStopStream can be returned by a stream callback to stop reading
+// responses without failing the request.
+var errStopStream = errors.New("stream stopped")
+
 type serviceMsg interface {
 	fromBytes([]byte)
 	toFlatBufferBytes(*flatbuffers.Builder) []byte
 	new() serviceMsg
 	getConn(*server) (net.Conn, error)
+	getID() string
+	getErr() error
+	// getFrame returns the backend frame the message was decoded from, if
+	// it was kept.
+	getFrame() []byte
+	setFrame([]byte)
+	idempotent() bool
+	setSelf(*http.Request) string
 }
 
 type service struct {
@@ -32,27 +49,185 @@ func (svc *service) reset() {
 	svc.builder.Reset()
 }
 
+// sync sends req and returns every response, each keeping a copy of the frame
+// it was decoded from.
 func (svc *service) sync(req serviceMsg) (resp []serviceMsg, err error) {
+	err = svc.stream(req, func(thisResp serviceMsg, frame []byte) error {
+		thisResp.setFrame(append([]byte(nil), frame...))
+		resp = append(resp, thisResp)
+		return nil
+	})
+	return
+}
+
+// stream sends req and calls fn with each response as it arrives, so callers
+// don't have to hold every response in memory. The raw frame passed to fn is
+// only valid until it returns.
+func (svc *service) stream(req serviceMsg, fn func(resp serviceMsg, frame []byte) error) (err error) {
+	var x *exchange
+	if rec := svc.host.recorder; rec != nil {
+		x = startExchange(req)
+		defer func() {
+			if recErr := rec.finish(x, err); recErr != nil {
+				log.Println("error recording backend exchange: ", recErr)
+			}
+		}()
+	}
+
 	conn, err := req.getConn(svc.host)
 	if err != nil {
 		return
 	}
 	defer conn.Close()
 
-	if _, err = prefixedio.WriteBytes(conn, req.toFlatBufferBytes(svc.builder)); err != nil {
+	frame := req.toFlatBufferBytes(svc.builder)
+	if x != nil {
+		x.request(frame)
+	}
+	if _, err = prefixedio.WriteBytes(conn, frame); err != nil {
 		return
 	}
 	for {
 		_, err = svc.buf.ReadFrom(conn)
 		if err == io.EOF {
-			err = nil
-			break
+			return nil
 		} else if err != nil {
 			return
 		}
+		if x != nil {
+			x.response(svc.buf.Bytes())
+		}
 		thisResp := req.new()
 		thisResp.fromBytes(svc.buf.Bytes())
-		resp = append(resp, thisResp)
+		if err = fn(thisResp, svc.buf.Bytes()); err == errStopStream {
+			return nil
+		} else if err != nil {
+			return
+		}
+	}
+}
+
+var errNoResponse = errors.New("connection closed without a response")
+
+// reuseProbeTimeout is how long syncEach waits to see whether the backend has
+// closed a connection before sending it another request.
+const reuseProbeTimeout = time.Millisecond
+
+// syncEach sends reqs, which must be for the same backend and each answered
+// by a single frame, and calls fn with the response to each, in order. They
+// are sent one at a time over one connection for as long as the backend
+// keeps it open. A backend that closes connections after one request, as the
+// services did before they kept them open, is redialed for the next. A
+// request that fails on a connection already used is only sent again on a
+// new one if none of it was written, or if it is idempotent, since the
+// backend may have applied it before the connection went.
+func (svc *service) syncEach(reqs []serviceMsg, fn func(i int, resp serviceMsg) error) error {
+	var conn net.Conn
+	var rd *bufio.Reader
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	used := false
+	for i := 0; i < len(reqs); {
+		if used && !stillOpen(conn, rd) {
+			conn.Close()
+			conn = nil
+			used = false
+		}
+		if conn == nil {
+			var err error
+			if conn, err = reqs[i].getConn(svc.host); err != nil {
+				return err
+			}
+			rd = bufio.NewReader(conn)
+		}
+		resp, written, err := svc.exchangeOne(conn, rd, reqs[i])
+		if err != nil {
+			conn.Close()
+			conn = nil
+			if used && (!written || reqs[i].idempotent()) {
+				used = false
+				continue
+			}
+			return err
+		}
+		if err := fn(i, resp); err != nil {
+			return err
+		}
+		used = true
+		i++
+	}
+	return nil
+}
+
+// stillOpen reports whether the backend has kept conn, read through rd, open
+// since its last response.
+func stillOpen(conn net.Conn, rd *bufio.Reader) bool {
+	conn.SetReadDeadline(time.Now().Add(reuseProbeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	_, err := rd.Peek(1)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	return err == nil
+}
+
+// exchangeOne sends req over conn and reads its only response frame from rd.
+// written reports whether any of req was written.
+func (svc *service) exchangeOne(conn net.Conn, rd io.Reader, req serviceMsg) (resp serviceMsg, written bool, err error) {
+	var x *exchange
+	if rec := svc.host.recorder; rec != nil {
+		x = startExchange(req)
+		defer func() {
+			if recErr := rec.finish(x, err); recErr != nil {
+				log.Println("error recording backend exchange: ", recErr)
+			}
+		}()
+	}
+
+	frame := req.toFlatBufferBytes(svc.builder)
+	if x != nil {
+		x.request(frame)
+	}
+	n, err := prefixedio.WriteBytes(conn, frame)
+	if written = n > 0; err != nil {
+		return
+	}
+	if _, err = svc.buf.ReadFrom(rd); err == io.EOF {
+		return nil, written, errNoResponse
+	} else if err != nil {
+		return
+	}
+	if x != nil {
+		x.response(svc.buf.Bytes())
+	}
+	resp = req.new()
+	resp.fromBytes(svc.buf.Bytes())
+	return resp, written, nil
+}
+
+// readIndex reads every item of an index, a page at a time, calling fn with
+// each. query returns the request for page p.
+func (svc *service) readIndex(query func(p page) serviceMsg, fn func(item serviceMsg) error) error {
+	p := page{limit: maxPageLimit}
+	for {
+		n := 0
+		var last string
+		err := svc.stream(query(p), func(resp serviceMsg, frame []byte) error {
+			if err := resp.getErr(); err != nil {
+				return err
+			}
+			if n++; n > p.limit {
+				return errStopStream
+			}
+			last = resp.getID()
+			return fn(resp)
+		})
+		if err != nil || n <= p.limit {
+			return err
+		}
+		p.after = last
 	}
-	return
 }
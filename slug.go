@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	minOrganizationNameLength = 2
+	maxOrganizationNameLength = 39
+	maxDisplayNameLength      = 100
+)
+
+const organizationNameRules = "Letters, digits and single hyphens, starting and ending with a letter or digit. " +
+	"Case-insensitive, and some names are reserved."
+
+// reservedOrganizationNames can't be organization names, so they stay free
+// for routes and don't impersonate the service.
+var reservedOrganizationNames = map[string]struct{}{
+	"admin":         {},
+	"api":           {},
+	"debug":         {},
+	"endpoints":     {},
+	"health-check":  {},
+	"help":          {},
+	"knollit":       {},
+	"new":           {},
+	"openapi":       {},
+	"organizations": {},
+	"root":          {},
+	"settings":      {},
+	"support":       {},
+	"system":        {},
+	"v1":            {},
+	"v2":            {},
+}
+
+// organizationSlug returns the slug an organization named name is addressed
+// by in URLs, or an error describing which rule name breaks. Slugs are
+// lowercase, so names are case-insensitive. They are 2 to 39 letters, digits
+// and single hyphens, starting and ending with a letter or digit.
+func organizationSlug(name string) (string, error) {
+	slug := strings.ToLower(name)
+	if len(slug) < minOrganizationNameLength {
+		return "", fmt.Errorf("must be at least %v characters", minOrganizationNameLength)
+	}
+	if len(slug) > maxOrganizationNameLength {
+		return "", fmt.Errorf("must be at most %v characters", maxOrganizationNameLength)
+	}
+	for _, c := range slug {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return "", fmt.Errorf("must contain only letters, digits and hyphens")
+		}
+	}
+	if strings.HasPrefix(slug, "-") || strings.HasSuffix(slug, "-") {
+		return "", fmt.Errorf("must start and end with a letter or digit")
+	}
+	if strings.Contains(slug, "--") {
+		return "", fmt.Errorf("must not contain consecutive hyphens")
+	}
+	if _, ok := reservedOrganizationNames[slug]; ok {
+		return "", fmt.Errorf("%q is reserved", slug)
+	}
+	return slug, nil
+}
+
+// requestOrganizationSlug returns the slug of the organization named in the
+// path of r, or an empty string if there is none.
+func requestOrganizationSlug(r *http.Request) string {
+	return strings.ToLower(mux.Vars(r)["organizationName"])
+}
+
+type organizationContextKey struct{}
+
+// withOrganization returns r carrying the name of org, the organization its
+// path names, so the URLs of the organization's resources use the name the
+// backend knows it by rather than the one in the path.
+func withOrganization(r *http.Request, org *organization) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), organizationContextKey{}, org.Name))
+}
+
+// requestOrganizationName returns the name of the organization r carries, or
+// the slug in its path if it carries none.
+func requestOrganizationName(r *http.Request) string {
+	if name, ok := r.Context().Value(organizationContextKey{}).(string); ok {
+		return name
+	}
+	return requestOrganizationSlug(r)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/knollit/http_frontend/endpoints"
+	"github.com/knollit/http_frontend/organizations"
+)
+
+// Conflict policies of imports, for endpoints with the URL of an existing
+// endpoint and for an organization that already exists.
+const (
+	conflictFail      = "fail"
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+)
+
+// Actions an import takes, or would take in a dry run.
+const (
+	importCreate    = "create"
+	importOverwrite = "overwrite"
+	importSkip      = "skip"
+	importConflict  = "conflict"
+)
+
+type importReport struct {
+	DryRun       bool           `json:"dryRun"`
+	Conflict     string         `json:"conflict"`
+	Organization importResult   `json:"organization"`
+	Endpoints    []importResult `json:"endpoints"`
+}
+
+type importResult struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Action string `json:"action"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// listEndpoints reads every endpoint of org, a page at a time.
+func (s *server) listEndpoints(r *http.Request, svc *service, org *organization) (all []*endpoint, err error) {
+	err = svc.readIndex(func(p page) serviceMsg {
+		return &endpoint{OrganizationID: org.Name, Action: endpoints.ActionIndex, caller: callerID(r), page: p}
+	}, func(item serviceMsg) error {
+		all = append(all, item.(*endpoint))
+		return nil
+	})
+	return
+}
+
+// exportHandler writes the organization and all its endpoints as a JSON or
+// tar archive.
+func (s *server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType := negotiate(r, jsonMediaType, tarMediaType)
+	if len(mediaType) == 0 {
+		http.Error(w, "not acceptable", http.StatusNotAcceptable)
+		return
+	}
+
+	svc := s.getService()
+	defer s.putService(svc)
+	org := s.findOrganization(w, r, svc)
+	if org == nil {
+		return
+	}
+	current, err := s.listEndpoints(r, svc, org)
+	if err != nil {
+		log.Printf("export request error %v", err)
+		http.Error(w, "internal application error", http.StatusInternalServerError)
+		return
+	}
+
+	a := &archive{
+		Version:      archiveVersion,
+		Organization: archivedOrganization{Name: org.Name, DisplayName: org.DisplayName},
+		Endpoints:    []archivedEndpoint{},
+	}
+	for _, e := range current {
+		a.Endpoints = append(a.Endpoints, archivedEndpoint{ID: e.ID, URL: e.URL, Schema: e.Schema})
+	}
+	data, err := encodeArchive(a, mediaType)
+	if err != nil {
+		log.Printf("export encoding error %v", err)
+		http.Error(w, "internal application error", http.StatusInternalServerError)
+		return
+	}
+	extension := ".json"
+	if mediaType == tarMediaType {
+		extension = ".tar"
+	}
+	w.Header().Set(contentTypeHeader, mediaType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": org.Name + extension}))
+	w.Write(data)
+}
+
+// importHandler loads an archive into the organization named in the path,
+// creating it if needed. Organizations and endpoints that already exist are
+// conflicts, and are failed, skipped or overwritten as the conflict
+// parameter says. Endpoints are matched by URL. Nothing is written if the
+// policy is to fail and there are conflicts, or if dryRun is set; the report
+// says what would have been done. An import that creates the organization
+// is answered 201, with its URL in the Location header.
+func (s *server) importHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(contentTypeHeader))
+	if mediaType != jsonMediaType && mediaType != tarMediaType {
+		http.Error(w, fmt.Sprintf("archives must be %v or %v", jsonMediaType, tarMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	q := r.URL.Query()
+	report := &importReport{Conflict: q.Get("conflict"), Endpoints: []importResult{}}
+	if len(report.Conflict) == 0 {
+		report.Conflict = conflictFail
+	}
+	report.DryRun, _ = strconv.ParseBool(q.Get("dryRun"))
+	a, err := readArchive(r.Body, mediaType)
+	if err != nil {
+		writeFieldErrors(w, &fieldError{In: "body", Message: err.Error(), malformed: true})
+		return
+	}
+	var errs []*fieldError
+	seen := make(map[string]int)
+	for i := range a.Endpoints {
+		field := fmt.Sprintf("endpoints[%d].url", i)
+		if a.Endpoints[i].URL, err = s.urlPolicy.normalize(r.Context(), a.Endpoints[i].URL); err != nil {
+			errs = append(errs, &fieldError{In: "body", Field: field, Message: err.Error()})
+		} else if j, ok := seen[a.Endpoints[i].URL]; ok {
+			errs = append(errs, &fieldError{In: "body", Field: field, Message: fmt.Sprintf("duplicates endpoints[%d].url", j)})
+		} else {
+			seen[a.Endpoints[i].URL] = i
+		}
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, errs...)
+		return
+	}
+
+	svc := s.getService()
+	defer s.putService(svc)
+	current, err := lookupOrganization(svc, mux.Vars(r)["organizationName"], callerID(r))
+	if err != nil && err != errOrganizationNotFound {
+		log.Printf("import request error %v", err)
+		http.Error(w, "internal application error", http.StatusInternalServerError)
+		return
+	}
+	// An existing organization keeps the name it was found under. A new one
+	// is named with the path's slug.
+	var name string
+	if current != nil {
+		r = withOrganization(r, current)
+		name = current.Name
+	} else if name, err = organizationSlug(mux.Vars(r)["organizationName"]); err != nil {
+		writeFieldErrors(w, &fieldError{In: "path", Field: "organizationName", Message: err.Error()})
+		return
+	}
+
+	// Plan the import
+	org := &organization{Name: name, DisplayName: a.Organization.DisplayName, caller: callerID(r)}
+	if len(org.DisplayName) == 0 {
+		org.DisplayName = name
+	}
+	report.Organization = importResult{Name: name, Action: importCreate}
+	existing := make(map[string]*endpoint)
+	if current != nil {
+		report.Organization.Action = conflictAction(report.Conflict)
+		org.ID = current.ID
+		currentEndpoints, err := s.listEndpoints(r, svc, current)
+		if err != nil {
+			log.Printf("import request error %v", err)
+			http.Error(w, "internal application error", http.StatusInternalServerError)
+			return
+		}
+		for _, e := range currentEndpoints {
+			existing[e.URL] = e
+		}
+	}
+	var creates, overwrites []serviceMsg
+	var createResults, overwriteResults []int
+	conflicts := report.Organization.Action == importConflict
+	for _, archived := range a.Endpoints {
+		result := importResult{URL: archived.URL, Action: importCreate}
+		e := &endpoint{OrganizationID: name, URL: archived.URL, Schema: archived.Schema, caller: callerID(r)}
+		if current, ok := existing[archived.URL]; ok {
+			result.Action = conflictAction(report.Conflict)
+			e.ID = current.ID
+		}
+		switch result.Action {
+		case importCreate:
+			e.Action = endpoints.ActionNew
+			creates = append(creates, e)
+			createResults = append(createResults, len(report.Endpoints))
+		case importOverwrite:
+			e.Action = endpoints.ActionUpdate
+			overwrites = append(overwrites, e)
+			overwriteResults = append(overwriteResults, len(report.Endpoints))
+		case importConflict:
+			conflicts = true
+		}
+		report.Endpoints = append(report.Endpoints, result)
+	}
+	if conflicts {
+		writeImportReport(w, http.StatusConflict, report)
+		return
+	}
+	if report.DryRun {
+		writeImportReport(w, http.StatusOK, report)
+		return
+	}
+
+	// Carry it out, recording the outcome of each request in its result
+	status := http.StatusOK
+	record := func(result *importResult, resps []serviceMsg, err error, success int) (ok bool) {
+		switch {
+		case err != nil:
+			log.Printf("import request error %v", err)
+			result.Status, result.Error = http.StatusBadGateway, "backend request failed"
+		case len(resps) == 0:
+			result.Status, result.Error = http.StatusBadGateway, "no response from the backend"
+		case resps[0].getErr() != nil:
+			result.Status, result.Error = http.StatusBadRequest, resps[0].getErr().Error()
+		default:
+			result.Status = success
+			return true
+		}
+		status = http.StatusMultiStatus
+		return false
+	}
+	switch report.Organization.Action {
+	case importCreate:
+		org.action = organizations.ActionNew
+	case importOverwrite:
+		org.action = organizations.ActionUpdate
+	}
+	if report.Organization.Action != importSkip {
+		resps, err := svc.sync(org)
+		if !record(&report.Organization, resps, err, http.StatusOK) {
+			writeImportReport(w, status, report)
+			return
+		}
+		if report.Organization.Action == importCreate {
+			status = http.StatusCreated
+			w.Header().Set("Location", resps[0].setSelf(r))
+		}
+	}
+	// Endpoints are sent one request each, over a single connection.
+	send := func(reqs []serviceMsg, results []int, success int) {
+		answered := 0
+		err := svc.syncEach(reqs, func(j int, resp serviceMsg) error {
+			record(&report.Endpoints[results[j]], []serviceMsg{resp}, nil, success)
+			answered++
+			return nil
+		})
+		for _, i := range results[answered:] {
+			record(&report.Endpoints[i], nil, err, success)
+		}
+	}
+	send(creates, createResults, http.StatusCreated)
+	send(overwrites, overwriteResults, http.StatusOK)
+	writeImportReport(w, status, report)
+}
+
+// conflictAction returns the import action for a conflict under policy.
+func conflictAction(policy string) string {
+	switch policy {
+	case conflictSkip:
+		return importSkip
+	case conflictOverwrite:
+		return importOverwrite
+	}
+	return importConflict
+}
+
+func writeImportReport(w http.ResponseWriter, status int, report *importReport) {
+	w.Header().Set(contentTypeHeader, jsonContentTypeValue)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
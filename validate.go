@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// fieldError describes why one request parameter or form field is invalid.
+type fieldError struct {
+	In      string `json:"in"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	// malformed is set when the value couldn't be parsed at all, as opposed
+	// to breaking a constraint.
+	malformed bool
+}
+
+var (
+	patternsMu sync.Mutex
+	patterns   = make(map[string]*regexp.Regexp)
+)
+
+func compiledPattern(pattern string) *regexp.Regexp {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+	re, ok := patterns[pattern]
+	if !ok {
+		re = regexp.MustCompile(pattern)
+		patterns[pattern] = re
+	}
+	return re
+}
+
+// check returns a fieldError if value doesn't satisfy schema.
+func (schema *openAPISchema) check(in, field, value string) *fieldError {
+	fail := func(malformed bool, format string, args ...interface{}) *fieldError {
+		return &fieldError{In: in, Field: field, Message: fmt.Sprintf(format, args...), malformed: malformed}
+	}
+	switch schema.Type {
+	case "integer":
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fail(true, "must be an integer")
+		}
+		if schema.Minimum != nil && float64(i) < *schema.Minimum {
+			return fail(false, "must be at least %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && float64(i) > *schema.Maximum {
+			return fail(false, "must be at most %v", *schema.Maximum)
+		}
+		return nil
+	}
+	if schema.MinLength != nil && utf8.RuneCountInString(value) < *schema.MinLength {
+		if *schema.MinLength == 1 {
+			return fail(false, "must not be empty")
+		}
+		return fail(false, "must be at least %v characters", *schema.MinLength)
+	}
+	if schema.MaxLength != nil && utf8.RuneCountInString(value) > *schema.MaxLength {
+		return fail(false, "must be at most %v characters", *schema.MaxLength)
+	}
+	if len(schema.Enum) > 0 {
+		valid := false
+		for _, e := range schema.Enum {
+			valid = valid || e == value
+		}
+		if !valid {
+			return fail(false, "must be one of %v", strings.Join(schema.Enum, ", "))
+		}
+	}
+	if len(schema.Pattern) > 0 && !compiledPattern(schema.Pattern).MatchString(value) {
+		return fail(false, "must match %v", schema.Pattern)
+	}
+	if schema.Format == "uri" {
+		if u, err := url.ParseRequestURI(value); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return fail(false, "must be an absolute URL")
+		}
+	}
+	return nil
+}
+
+// validateRequest checks the path, query and form body of r against op.
+func validateRequest(op *openAPIOperation, r *http.Request) (errs []*fieldError) {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	for _, param := range op.Parameters {
+		var value string
+		var present bool
+		switch param.In {
+		case "path":
+			value, present = vars[param.Name]
+		case "query":
+			_, present = query[param.Name]
+			value = query.Get(param.Name)
+		default:
+			continue
+		}
+		if !present {
+			if param.Required {
+				errs = append(errs, &fieldError{In: param.In, Field: param.Name, Message: "is required"})
+			}
+			continue
+		}
+		if err := param.Schema.check(param.In, param.Name, value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if op.RequestBody == nil {
+		return
+	}
+	form, ok := op.RequestBody.Content["application/x-www-form-urlencoded"]
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		return append(errs, &fieldError{In: "body", Message: "could not be parsed", malformed: true})
+	}
+	body := form.Schema
+	for _, name := range body.Required {
+		if _, ok := r.PostForm[name]; !ok {
+			errs = append(errs, &fieldError{In: "body", Field: name, Message: "is required"})
+		}
+	}
+	for name, schema := range body.Properties {
+		if _, ok := r.PostForm[name]; !ok {
+			continue
+		}
+		if err := schema.check("body", name, r.PostForm.Get(name)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return
+}
+
+// validate checks requests against the operations documented for their
+// route before calling next. Requests that can't be parsed get 400, and
+// those breaking a constraint get 422, listing every invalid field.
+func validate(item openAPIPathItem, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		op := item[strings.ToLower(r.Method)]
+		if op == nil {
+			next(w, r)
+			return
+		}
+		errs := validateRequest(op, r)
+		if len(errs) == 0 {
+			next(w, r)
+			return
+		}
+
+		writeFieldErrors(w, errs...)
+	}
+}
+
+// writeFieldErrors responds with errs: 400 if any value couldn't be parsed,
+// otherwise 422.
+func writeFieldErrors(w http.ResponseWriter, errs ...*fieldError) {
+	status := http.StatusUnprocessableEntity
+	for _, err := range errs {
+		if err.malformed {
+			status = http.StatusBadRequest
+		}
+	}
+	w.Header().Set(contentTypeHeader, jsonContentTypeValue)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string][]*fieldError{"errors": errs})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// apiRoute is a route in one API version, relative to the version's prefix.
+type apiRoute struct {
+	path    string
+	methods httpMethods
+	policy  policy
+	handler http.HandlerFunc
+}
+
+// apiVersion is one generation of the public API: the prefix its routes are
+// served under and how it represents resources to clients, independently of
+// the structs mapped to the backends' flatbuffers.
+type apiVersion struct {
+	name      string
+	prefix    string
+	represent func(serviceMsg) interface{}
+	// envelope wraps JSON listings in an object with the items under "data"
+	// and the next page's cursor under "next".
+	envelope bool
+	// deprecated and sunset are zero unless the version is being retired.
+	deprecated time.Time
+	sunset     time.Time
+}
+
+var (
+	// legacyAPI serves v1's routes without a prefix, with the original
+	// representation of the internal structs, for clients that predate
+	// versioning.
+	legacyAPI = &apiVersion{
+		represent:  representLegacy,
+		deprecated: time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC),
+		sunset:     time.Date(2027, time.May, 1, 0, 0, 0, 0, time.UTC),
+	}
+	v1API = &apiVersion{
+		name:      "1",
+		prefix:    "/v1",
+		represent: representV1,
+	}
+	v2API = &apiVersion{
+		name:      "2",
+		prefix:    "/v2",
+		represent: representV2,
+		envelope:  true,
+	}
+	apiVersions = []*apiVersion{v1API, v2API}
+)
+
+// apiRoutes returns the handler set of API version v. The legacy API
+// shares v1's.
+func (s *server) apiRoutes(v *apiVersion) []apiRoute {
+	if v == v2API {
+		return s.v2Routes()
+	}
+	return s.v1Routes()
+}
+
+func (s *server) v1Routes() []apiRoute {
+	return []apiRoute{
+		{"/organizations", httpMethods{http.MethodGet, http.MethodPost}, organizationsPolicy, s.organizationsHandler},
+		{"/organizations/{organizationName}", httpMethods{http.MethodGet, http.MethodPut, http.MethodDelete}, organizationPolicy, s.organizationHandler},
+		{"/organizations/{organizationName}/endpoints", httpMethods{http.MethodGet, http.MethodPost}, endpointPolicy, s.endpointsHandler},
+		{"/organizations/{organizationName}/endpoints/{endpointID}", httpMethods{http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete}, endpointPolicy, s.endpointHandler},
+		{"/organizations/{organizationName}/endpoints:batch", httpMethods{http.MethodPost}, endpointPolicy, s.endpointsBatchHandler},
+		{"/organizations/{organizationName}/export", httpMethods{http.MethodGet}, organizationPolicy, s.exportHandler},
+		{"/organizations/{organizationName}/import", httpMethods{http.MethodPost}, importPolicy, s.importHandler},
+		{"/organizations/{organizationName}/members", httpMethods{http.MethodGet}, memberPolicy, s.membersHandler},
+		{"/organizations/{organizationName}/members/{memberID}", httpMethods{http.MethodGet, http.MethodPut, http.MethodDelete}, memberPolicy, s.memberHandler},
+	}
+}
+
+// v2Routes are the routes of v2. So far they're v1's, with listings
+// enveloped.
+func (s *server) v2Routes() []apiRoute {
+	return []apiRoute{
+		{"/organizations", httpMethods{http.MethodGet, http.MethodPost}, organizationsPolicy, s.organizationsHandler},
+		{"/organizations/{organizationName}", httpMethods{http.MethodGet, http.MethodPut, http.MethodDelete}, organizationPolicy, s.organizationHandler},
+		{"/organizations/{organizationName}/endpoints", httpMethods{http.MethodGet, http.MethodPost}, endpointPolicy, s.endpointsHandler},
+		{"/organizations/{organizationName}/endpoints/{endpointID}", httpMethods{http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete}, endpointPolicy, s.endpointHandler},
+		{"/organizations/{organizationName}/endpoints:batch", httpMethods{http.MethodPost}, endpointPolicy, s.endpointsBatchHandler},
+		{"/organizations/{organizationName}/export", httpMethods{http.MethodGet}, organizationPolicy, s.exportHandler},
+		{"/organizations/{organizationName}/import", httpMethods{http.MethodPost}, importPolicy, s.importHandler},
+		{"/organizations/{organizationName}/members", httpMethods{http.MethodGet}, memberPolicy, s.membersHandler},
+		{"/organizations/{organizationName}/members/{memberID}", httpMethods{http.MethodGet, http.MethodPut, http.MethodDelete}, memberPolicy, s.memberHandler},
+	}
+}
+
+type apiVersionContextKey struct{}
+
+// serve makes v the API version of requests passed to next, and marks their
+// responses if v is deprecated.
+func (v *apiVersion) serve(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !v.deprecated.IsZero() {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", v.deprecated.Unix()))
+		}
+		if !v.sunset.IsZero() {
+			w.Header().Set("Sunset", v.sunset.UTC().Format(http.TimeFormat))
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), apiVersionContextKey{}, v)))
+	}
+}
+
+// requestAPIVersion returns the API version r is served under.
+func requestAPIVersion(r *http.Request) *apiVersion {
+	if v, ok := r.Context().Value(apiVersionContextKey{}).(*apiVersion); ok {
+		return v
+	}
+	return legacyAPI
+}
+
+// acceptedAPIVersion returns the API version named by a version parameter
+// in the Accept header, the legacy API if there is none, or nil if it names
+// an unknown version.
+func acceptedAPIVersion(r *http.Request) *apiVersion {
+	for _, spec := range strings.Split(r.Header.Get("Accept"), ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(spec))
+		if err != nil {
+			continue
+		}
+		if name, ok := params["version"]; ok {
+			for _, v := range apiVersions {
+				if v.name == name {
+					return v
+				}
+			}
+			return nil
+		}
+	}
+	return legacyAPI
+}
+
+// routeAPIVersions registers every API version's routes under its prefix,
+// validating requests against doc. The unversioned routes serve the version
+// named in the Accept header, or the legacy API. Rate limits are shared
+// between a route's versions, and the limit by IP between every route.
+func (s *server) routeAPIVersions(r *mux.Router, doc *openAPIDocument) {
+	limiters := make(routeLimiters)
+	ipLimiter := newRateLimiter(s.ipLimits)
+	unversioned := make(map[string]map[*apiVersion]http.HandlerFunc)
+	var unversionedPaths []string
+	for _, v := range append([]*apiVersion{legacyAPI}, apiVersions...) {
+		for _, route := range s.apiRoutes(v) {
+			h := validate(doc.Paths[v.prefix+route.path], route.handler)
+			h = s.shed(route.path, s.limitBody(route.path, s.idempotent(h)))
+			h = v.serve(route.methods.route(ipLimiter.limit(s.authorize(route.policy, limiters.limit(s, route.path, h)))))
+			if _, ok := unversioned[route.path]; !ok {
+				unversioned[route.path] = make(map[*apiVersion]http.HandlerFunc)
+				unversionedPaths = append(unversionedPaths, route.path)
+			}
+			unversioned[route.path][v] = h
+			if v != legacyAPI {
+				r.HandleFunc(v.prefix+route.path, h)
+			}
+		}
+	}
+	for _, path := range unversionedPaths {
+		handlers := unversioned[path]
+		r.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			h, ok := handlers[acceptedAPIVersion(r)]
+			if !ok {
+				http.Error(w, "unsupported API version", http.StatusNotAcceptable)
+				return
+			}
+			h(w, r)
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/google/flatbuffers/go"
+)
+
+// watchBacklog is how many polls' events a watch may fall behind by before
+// it is ended.
+const watchBacklog = 16
+
+// watchEvent is a change to a watched index: the frame of an item "created",
+// "updated" or "deleted", or an "error" that ended the watch.
+type watchEvent struct {
+	name  string
+	frame []byte
+	err   error
+}
+
+// watchHub holds the pollers of a server's watches by query. Its mutex also
+// guards the pollers.
+type watchHub struct {
+	mu      sync.Mutex
+	pollers map[string]*watchPoller
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{pollers: make(map[string]*watchPoller)}
+}
+
+// watchPoller polls an index query on behalf of every watch of it, so the
+// backend is polled once per interval however many clients watch the same
+// query. Each watch is sent the events of every poll.
+type watchPoller struct {
+	hub     *watchHub
+	key     string
+	polled  bool
+	items   []indexItem // as of the last poll, in backend order
+	watches map[chan []watchEvent]struct{}
+	stop    chan struct{}
+}
+
+// watchKey identifies the query of an Index request, whoever sends it.
+func watchKey(req serviceMsg) string {
+	switch msg := req.(type) {
+	case *organization:
+		query := *msg
+		query.caller = ""
+		return organizationService + string(query.toFlatBufferBytes(flatbuffers.NewBuilder(0)))
+	case *endpoint:
+		query := *msg
+		query.caller = ""
+		return endpointService + string(query.toFlatBufferBytes(flatbuffers.NewBuilder(0)))
+	}
+	return ""
+}
+
+// watch subscribes to the index req queries, starting a poller for it if
+// there isn't one yet, and returns the channel events arrive on and a func
+// ending the subscription. A poller sends its requests as the watch that
+// started it. Subscribers joining a running poller are first sent its items
+// as "created" events. The channel is closed when the watch ends, after an
+// "error" event if the backend failed.
+func (s *server) watch(req serviceMsg, limit int) (<-chan []watchEvent, func()) {
+	hub := s.watches
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	key := watchKey(req)
+	p, ok := hub.pollers[key]
+	if !ok {
+		p = &watchPoller{
+			hub:     hub,
+			key:     key,
+			watches: make(map[chan []watchEvent]struct{}),
+			stop:    make(chan struct{}),
+		}
+		hub.pollers[key] = p
+		go s.poll(p, req, limit)
+	}
+	events := make(chan []watchEvent, watchBacklog)
+	if p.polled && len(p.items) > 0 {
+		current := make([]watchEvent, len(p.items))
+		for i, item := range p.items {
+			current[i] = watchEvent{name: "created", frame: item.frame}
+		}
+		events <- current
+	}
+	p.watches[events] = struct{}{}
+	return events, func() {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		p.remove(events)
+	}
+}
+
+// remove ends the watch events are sent on, and stops p once no watches
+// remain. The hub must be locked.
+func (p *watchPoller) remove(events chan []watchEvent) {
+	if _, ok := p.watches[events]; !ok {
+		return
+	}
+	delete(p.watches, events)
+	close(events)
+	if len(p.watches) == 0 {
+		if p.hub.pollers[p.key] == p {
+			delete(p.hub.pollers, p.key)
+		}
+		close(p.stop)
+	}
+}
+
+// poll polls the index every watchInterval and sends the differences to the
+// watches of p until they're all gone, or the backend fails.
+func (s *server) poll(p *watchPoller, req serviceMsg, limit int) {
+	hub := p.hub
+	for {
+		items, err := s.pollIndex(req, limit)
+		hub.mu.Lock()
+		select {
+		case <-p.stop:
+			hub.mu.Unlock()
+			return
+		default:
+		}
+		batch := []watchEvent{{name: "error", err: err}}
+		if err == nil {
+			batch = diffIndex(p.items, items)
+			p.items, p.polled = items, true
+		}
+		if len(batch) > 0 {
+			for events := range p.watches {
+				select {
+				case events <- batch:
+					if err != nil {
+						p.remove(events)
+					}
+				default:
+					// The watch fell behind.
+					p.remove(events)
+				}
+			}
+		}
+		hub.mu.Unlock()
+
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(s.watchInterval):
+		}
+	}
+}
+
+// pollIndex reads up to limit items of an index.
+func (s *server) pollIndex(req serviceMsg, limit int) (items []indexItem, err error) {
+	svc := s.getService()
+	defer s.putService(svc)
+	err = svc.stream(req, func(resp serviceMsg, frame []byte) error {
+		if err := resp.getErr(); err != nil {
+			return err
+		}
+		if len(items) == limit {
+			return errStopStream
+		}
+		items = append(items, indexItem{msg: resp, frame: append([]byte(nil), frame...)})
+		return nil
+	})
+	return
+}
+
+// diffIndex returns the events turning the items of prev into those of
+// current.
+func diffIndex(prev, current []indexItem) (events []watchEvent) {
+	seen := make(map[string][]byte, len(prev))
+	for _, item := range prev {
+		seen[item.msg.getID()] = item.frame
+	}
+	for _, item := range current {
+		id := item.msg.getID()
+		if frame, found := seen[id]; !found {
+			events = append(events, watchEvent{name: "created", frame: item.frame})
+		} else if !bytes.Equal(frame, item.frame) {
+			events = append(events, watchEvent{name: "updated", frame: item.frame})
+		}
+		delete(seen, id)
+	}
+	for _, item := range prev {
+		if _, deleted := seen[item.msg.getID()]; deleted {
+			events = append(events, watchEvent{name: "deleted", frame: item.frame})
+		}
+	}
+	return
+}